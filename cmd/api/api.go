@@ -1,58 +1,281 @@
 package api
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Waris-Shaik/todo/logging"
+	"github.com/Waris-Shaik/todo/metrics"
+	"github.com/Waris-Shaik/todo/passwordpolicy"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/services/health"
 	"github.com/Waris-Shaik/todo/services/todo"
 	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
 	"github.com/Waris-Shaik/todo/utils"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
 type APIServer struct {
-	addr string
-	db   *sql.DB
+	addr   string
+	stores Stores
+	config ServerConfig
+
+	server   *http.Server
+	listener net.Listener
+	serveErr chan error
+
+	// stopJobs cancels the background jobs, which close jobsDone once stopped
+	stopJobs context.CancelFunc
+	jobsDone chan struct{}
 }
 
-func NewAPIServer(addr string, db *sql.DB) *APIServer {
-	return &APIServer{addr: addr, db: db}
+// ServerConfig holds the HTTP server timeouts, where zero means no timeout,
+// and the rate limits.
+type ServerConfig struct {
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// RevocationCacheTTL is how long token revocation checks are cached
+	RevocationCacheTTL time.Duration
+
+	// Limits throttles login and registration
+	Limits user.Limits
+	// Emails sends verification and other account mail
+	Emails user.Emails
+	// Deletion is how long deleted accounts are kept and how often they are purged
+	Deletion user.DeletionPolicy
+	// Passwords decides which new passwords are accepted
+	Passwords passwordpolicy.Policy
+	// SSO logs users in with an external OpenID Connect provider
+	SSO user.SSO
+	// TwoFactor configures two-factor authentication with authenticator apps
+	TwoFactor user.TwoFactor
 }
 
-func (s *APIServer) Run() error {
+// Stores groups the storage backends the handlers are built on.
+type Stores struct {
+	// DB is the connection pool behind the stores, nil for in-memory storage.
+	DB *sql.DB
+	// Migrations are the migration files DB is expected to be up to date with.
+	Migrations fs.FS
+
+	Users          types.UserStore
+	LoginAttempts  types.LoginAttemptStore
+	Todos          types.TodoStore
+	RefreshTokens  types.RefreshTokenStore
+	PasswordResets types.PasswordResetStore
+	Revocations    types.TokenRevocationStore
+	AccessTokens   types.PersonalAccessTokenStore
+	Identities     types.UserIdentityStore
+	MFA            types.MFAStore
+}
+
+// NewSQLStores returns the stores backed by db, which may be MySQL or SQLite,
+// and the migrations its schema should be at.
+func NewSQLStores(db *sql.DB, migrations fs.FS) Stores {
+	tokenStore := auth.NewStore(db)
+	userStore := user.NewStore(db)
+	return Stores{
+		DB:             db,
+		Migrations:     migrations,
+		Users:          userStore,
+		LoginAttempts:  userStore,
+		Todos:          todo.NewStore(db),
+		RefreshTokens:  tokenStore,
+		PasswordResets: tokenStore,
+		Revocations:    tokenStore,
+		AccessTokens:   tokenStore,
+		Identities:     userStore,
+		MFA:            userStore,
+	}
+}
+
+// NewMemoryStores returns stores that keep everything in memory.
+func NewMemoryStores() Stores {
+	tokenStore := auth.NewMemoryStore()
+	userStore := user.NewMemoryStore()
+	return Stores{
+		Users:          userStore,
+		LoginAttempts:  userStore,
+		Todos:          todo.NewMemoryStore(),
+		RefreshTokens:  tokenStore,
+		PasswordResets: tokenStore,
+		Revocations:    tokenStore,
+		AccessTokens:   tokenStore,
+		Identities:     userStore,
+		MFA:            userStore,
+	}
+}
+
+func NewAPIServer(addr string, stores Stores, config ServerConfig) *APIServer {
+	return &APIServer{addr: addr, stores: stores, config: config}
+}
+
+// Handler builds the router with every route and middleware attached.
+func (s *APIServer) Handler() http.Handler {
 	router := mux.NewRouter().StrictSlash(true)
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "static/index.html")
 	})
 
+	// liveness and readiness probes
+	healthHandler := health.NewHandler(s.stores.DB, s.stores.Migrations)
+	healthHandler.RegisterRoutes(router)
+
+	// prometheus metrics
+	if s.stores.DB != nil {
+		metrics.RegisterDB(s.stores.DB, "todo")
+	}
+	router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	// public keys for services verifying our access tokens
+	router.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS).Methods(http.MethodGet)
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
-	// user-store
-	userStore := user.NewStore(s.db)
+	// revocation checks are cached in memory in front of the store
+	revocations := auth.NewRevocationCache(s.stores.Revocations, s.config.RevocationCacheTTL)
+
 	// user-handler
-	userHandler := user.NewHandler(userStore)
+	userHandler := user.NewHandler(s.stores.Users, s.stores.Todos, s.stores.LoginAttempts, s.stores.RefreshTokens, s.stores.PasswordResets, revocations, s.stores.AccessTokens, s.stores.Identities, s.stores.MFA, s.config.Limits, s.config.Emails, s.config.Deletion, s.config.Passwords, s.config.SSO, s.config.TwoFactor)
 	userHandler.RegisterRoutes(subrouter)
 
-	// todo-store
-	todoStore := todo.NewStore(s.db)
 	// todo-handler
-	todoHandler := todo.NewHandler(todoStore, userStore)
+	todoHandler := todo.NewHandler(s.stores.Todos, s.stores.Users, revocations, s.stores.AccessTokens)
 	todoHandler.RegisterRoutes(subrouter)
 
 	// CORS configuration
 	frontendURL := os.Getenv("FRONTEND_URL")
 	corsOptions := handlers.AllowedOrigins([]string{frontendURL})
 	corsMethods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE"})
-	corsHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization"})
+	corsHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization", logging.RequestIDHeader})
+	corsExposed := handlers.ExposedHeaders([]string{logging.RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "WWW-Authenticate"})
 	corsCredentials := handlers.AllowCredentials()
 
-	// Apply CORS middleware
-	handler := handlers.CORS(corsOptions, corsMethods, corsHeaders, corsCredentials)(router)
+	// Apply CORS middleware, inside the instrumentation and request logging so
+	// preflights are counted and logged too
+	handler := handlers.CORS(corsOptions, corsMethods, corsHeaders, corsExposed, corsCredentials)(router)
+	return logging.Middleware(metrics.Instrument(router, handler))
+}
+
+// Start listens on the server address and serves in the background. It
+// returns once the listener is open, so Addr is valid afterwards.
+func (s *APIServer) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: s.config.ReadTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	s.serveErr = make(chan error, 1)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.serveErr <- err
+		}
+		close(s.serveErr)
+	}()
+
+	// purge accounts whose deletion grace period is over
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	s.stopJobs = stopJobs
+	s.jobsDone = make(chan struct{})
+	go func() {
+		defer close(s.jobsDone)
+		user.NewPurger(s.stores.Users, s.stores.Todos, s.config.Deletion).Run(jobsCtx)
+	}()
+
+	slog.Info("server listening", "addr", listener.Addr().String(), "env", utils.GetNodeENV("NODE_ENV"))
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil before Start.
+func (s *APIServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections, waits for in-flight requests until ctx
+// is done and then closes the database pool.
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	var shutdownErr error
+	if s.server != nil {
+		shutdownErr = s.server.Shutdown(ctx)
+	}
+
+	// let a running purge finish before the database goes away
+	if s.stopJobs != nil {
+		s.stopJobs()
+		select {
+		case <-s.jobsDone:
+		case <-ctx.Done():
+		}
+	}
+
+	if s.stores.DB != nil {
+		if err := s.stores.DB.Close(); err != nil {
+			slog.Error("closing database failed", "err", err)
+			if shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+	}
+	return shutdownErr
+}
+
+// Run starts the server and blocks until SIGINT or SIGTERM, then drains
+// in-flight requests for up to the configured shutdown timeout.
+func (s *APIServer) Run() error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err, ok := <-s.serveErr:
+		if ok {
+			s.Shutdown(context.Background())
+			return err
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down, waiting for in-flight requests to finish")
+	}
+
+	shutdownCtx := context.Background()
+	if s.config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.config.ShutdownTimeout)
+		defer cancel()
+	}
 
-	log.Printf("Server is listening on PORT %v in %v mode⚡⚡⚡ \n", s.addr, utils.GetNodeENV("NODE_ENV"))
-	return http.ListenAndServe(s.addr, handler)
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	slog.Info("server stopped")
+	return nil
 }
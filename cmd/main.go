@@ -4,18 +4,31 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 
 	"github.com/Waris-Shaik/todo/cmd/api"
+	"github.com/Waris-Shaik/todo/cmd/migrate/migrations"
 	"github.com/Waris-Shaik/todo/configs"
 	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/logging"
+	"github.com/Waris-Shaik/todo/mailer"
+	"github.com/Waris-Shaik/todo/oidc"
+	"github.com/Waris-Shaik/todo/passwordpolicy"
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/services/user"
 	"github.com/go-sql-driver/mysql"
 )
 
 func main() {
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.SetOutput(os.Stdout)
+	logger, err := logging.New(os.Stdout, configs.Envs.LogFormat, configs.Envs.LogLevel)
+	if err != nil {
+		log.Fatal("Could not set up logging:", err)
+	}
+	// also routes the standard log package through the structured logger
+	slog.SetDefault(logger)
 
 	// get the port from .env file
 	port := os.Getenv("PORT")
@@ -24,26 +37,80 @@ func main() {
 	}
 	port = fmt.Sprintf(":%v", port)
 
-	db, err := db.MyNewSQLStorage(mysql.Config{
-		User:                 configs.Envs.DBUser,
-		Passwd:               configs.Envs.DBPassword,
-		Addr:                 configs.Envs.DBAddress,
-		DBName:               configs.Envs.DBName,
-		Net:                  "tcp",
-		AllowNativePasswords: true,
-		ParseTime:            true,
-	})
+	db.QueryTimeout = configs.Envs.DBQueryTimeout
 
-	if err != nil {
-		log.Fatal("Could not connect to database successfully", err)
+	for _, name := range configs.Envs.VerifiedEmailRoutes {
+		auth.VerifiedEmailRoutes[name] = true
 	}
+	auth.Keys = newKeySet()
+	auth.Secrets = newSecretCipher()
+
+	var stores api.Stores
+	switch configs.Envs.DBDriver {
+	case "memory":
+		slog.Warn("using in-memory storage, data will be lost on restart")
+		stores = api.NewMemoryStores()
+	case "sqlite":
+		sqliteDB, err := db.NewSQLiteStorage(configs.Envs.SQLitePath)
+		if err != nil {
+			fatal("could not open sqlite database", err)
+		}
+		slog.Info("using sqlite database", "path", configs.Envs.SQLitePath)
+		stores = api.NewSQLStores(sqliteDB, db.SQLiteMigrations())
+	default:
+		db, err := db.MyNewSQLStorage(mysql.Config{
+			User:                 configs.Envs.DBUser,
+			Passwd:               configs.Envs.DBPassword,
+			Addr:                 configs.Envs.DBAddress,
+			DBName:               configs.Envs.DBName,
+			Net:                  "tcp",
+			AllowNativePasswords: true,
+			ParseTime:            true,
+		})
 
-	initStorage(db)
+		if err != nil {
+			fatal("could not connect to database", err)
+		}
+
+		initStorage(db)
+		stores = api.NewSQLStores(db, migrations.FS)
+	}
 
 	// server-instance
-	server := api.NewAPIServer(port, db)
+	server := api.NewAPIServer(port, stores, api.ServerConfig{
+		ReadTimeout:        configs.Envs.HTTPReadTimeout,
+		WriteTimeout:       configs.Envs.HTTPWriteTimeout,
+		IdleTimeout:        configs.Envs.HTTPIdleTimeout,
+		ShutdownTimeout:    configs.Envs.ShutdownTimeout,
+		RevocationCacheTTL: configs.Envs.RevocationCacheTTL,
+		Limits: user.Limits{
+			LoginIP:           ratelimit.NewMemoryLimiter(configs.Envs.LoginIPRate),
+			LoginAccount:      ratelimit.NewMemoryLimiter(configs.Envs.LoginAccountRate),
+			RegisterIP:        ratelimit.NewMemoryLimiter(configs.Envs.RegisterIPRate),
+			TrustProxy:        configs.Envs.TrustProxy,
+			VerificationEmail: ratelimit.NewMemoryLimiter(configs.Envs.VerificationEmailRate),
+			PasswordForgotIP:  ratelimit.NewMemoryLimiter(configs.Envs.PasswordForgotRate),
+			Lockout: user.LockoutPolicy{
+				Threshold: configs.Envs.LockoutThreshold,
+				Base:      configs.Envs.LockoutBase,
+				Max:       configs.Envs.LockoutMax,
+			},
+		},
+		Emails: user.Emails{
+			Mailer:           newMailer(),
+			BaseURL:          configs.Envs.AppURL,
+			PasswordResetURL: configs.Envs.PasswordResetURL,
+		},
+		Deletion: user.DeletionPolicy{
+			Grace:         configs.Envs.AccountDeletionGrace,
+			PurgeInterval: configs.Envs.AccountPurgeInterval,
+		},
+		Passwords: newPasswordPolicy(),
+		SSO:       newSSO(),
+		TwoFactor: user.TwoFactor{Issuer: configs.Envs.MFAIssuer},
+	})
 	if err := server.Run(); err != nil {
-		log.Fatal("Could not start server:", err)
+		fatal("could not start server", err)
 	}
 
 }
@@ -51,7 +118,106 @@ func main() {
 func initStorage(db *sql.DB) {
 	err := db.Ping()
 	if err != nil {
-		log.Fatalf("Error while pinging database: %v", err)
+		fatal("could not ping database", err)
+	}
+	slog.Info("connected to database", "host", configs.Envs.DBAddress)
+}
+
+func newMailer() mailer.Mailer {
+	switch configs.Envs.Mailer {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     configs.Envs.SMTPHost,
+			Port:     configs.Envs.SMTPPort,
+			Username: configs.Envs.SMTPUsername,
+			Password: configs.Envs.SMTPPassword,
+			From:     configs.Envs.MailFrom,
+		})
+	case "file":
+		m, err := mailer.NewFileMailer(configs.Envs.MailDir, configs.Envs.MailFrom)
+		if err != nil {
+			fatal("could not create mail directory", err)
+		}
+		slog.Info("writing mail to files", "dir", configs.Envs.MailDir)
+		return m
+	default:
+		slog.Warn("mail is only logged, set MAILER=smtp to send it")
+		return mailer.LogMailer{}
+	}
+}
+
+func newPasswordPolicy() passwordpolicy.Policy {
+	policy := passwordpolicy.Policy{
+		MinLength:        configs.Envs.PasswordMinLength,
+		MaxLength:        configs.Envs.PasswordMaxLength,
+		MinClasses:       configs.Envs.PasswordMinClasses,
+		MinScore:         configs.Envs.PasswordMinScore,
+		DisallowPersonal: configs.Envs.PasswordDisallowPersonal,
+	}
+	if err := policy.Validate(); err != nil {
+		fatal("invalid password policy", err)
+	}
+
+	if path := configs.Envs.PasswordBreachedList; path != "" {
+		breached, err := passwordpolicy.LoadBreachedList(path)
+		if err != nil {
+			fatal("could not load breached password list", err)
+		}
+		slog.Info("loaded breached password list", "path", path, "entries", breached.Len())
+		policy.Breached = breached
 	}
-	log.Printf("Connected to database successfully on host:%v🔥🔥🔥 \n", configs.Envs.DBAddress)
+	return policy
+}
+
+func newKeySet() *auth.KeySet {
+	keys, err := auth.NewKeySet(auth.KeyConfig{
+		Algorithm:              configs.Envs.JWTAlgorithm,
+		Secret:                 configs.Envs.JWTSecretKey,
+		PreviousSecrets:        configs.Envs.JWTPreviousSecretKeys,
+		PrivateKeyFile:         configs.Envs.JWTPrivateKeyFile,
+		PreviousPublicKeyFiles: configs.Envs.JWTPublicKeyFiles,
+		Issuer:                 configs.Envs.JWTIssuer,
+		Audience:               configs.Envs.JWTAudience,
+	})
+	if err != nil {
+		fatal("could not load JWT signing keys", err)
+	}
+	slog.Info("loaded JWT signing keys", "algorithm", configs.Envs.JWTAlgorithm, "kid", keys.CurrentKeyID(), "keys", keys.Len())
+	return keys
+}
+
+func newSSO() user.SSO {
+	if configs.Envs.OIDCIssuer == "" {
+		return user.SSO{}
+	}
+	slog.Info("single sign-on enabled", "issuer", configs.Envs.OIDCIssuer, "redirect_url", configs.Envs.OIDCRedirectURL)
+	return user.SSO{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:       configs.Envs.OIDCIssuer,
+			ClientID:     configs.Envs.OIDCClientID,
+			ClientSecret: configs.Envs.OIDCClientSecret,
+			RedirectURL:  configs.Envs.OIDCRedirectURL,
+			Scopes:       configs.Envs.OIDCScopes,
+		}),
+		PostLoginURL: configs.Envs.OIDCPostLoginURL,
+	}
+}
+
+func newSecretCipher() *auth.SecretCipher {
+	if configs.Envs.MFAEncryptionKey == "" {
+		slog.Warn("two-factor authentication is off, set MFA_ENCRYPTION_KEY to enable it")
+		return nil
+	}
+	secrets, err := auth.NewSecretCipher(configs.Envs.MFAEncryptionKey, configs.Envs.MFAPreviousEncryptionKeys)
+	if err != nil {
+		fatal("could not load MFA encryption keys", err)
+	}
+	slog.Info("two-factor authentication enabled", "keys", secrets.Len())
+	return secrets
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1 @@
+ALTER TABLE todo ADD INDEX `idx_todo_user` (`userID`), DROP INDEX `idx_todo_user_created`;
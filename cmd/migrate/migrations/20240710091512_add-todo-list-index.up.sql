@@ -0,0 +1 @@
+CREATE INDEX `idx_todo_user_created` ON todo (`userID`, `created_at`);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refresh_token (
+    `id` INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` INT UNSIGNED NOT NULL,
+    `family_id` CHAR(32) NOT NULL,
+    `token_hash` CHAR(64) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `used_at` TIMESTAMP NULL DEFAULT NULL,
+    `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `unique_token_hash` (`token_hash`),
+    KEY `idx_refresh_token_family` (`family_id`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
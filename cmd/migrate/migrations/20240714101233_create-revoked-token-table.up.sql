@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS revoked_token (
+    `jti` CHAR(32) PRIMARY KEY,
+    `user_id` INT UNSIGNED NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `revoked_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY `idx_revoked_token_expires` (`expires_at`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_token_cutoff (
+    `user_id` INT UNSIGNED PRIMARY KEY,
+    `revoked_before` TIMESTAMP NOT NULL,
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS login_attempt (
+    `id` INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` INT UNSIGNED NULL DEFAULT NULL,
+    `identifier` VARCHAR(255) NOT NULL,
+    `ip` VARCHAR(45) NOT NULL,
+    `user_agent` VARCHAR(512) NOT NULL DEFAULT '',
+    `success` BOOLEAN NOT NULL,
+    `reason` VARCHAR(32) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY `idx_login_attempt_user` (`user_id`, `id`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+UPDATE user SET `email_verified_at` = NULL;
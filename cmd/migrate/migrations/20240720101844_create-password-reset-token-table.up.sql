@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_reset_token (
+    `id` INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` INT UNSIGNED NOT NULL,
+    `token_hash` CHAR(64) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `used_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `unique_reset_token_hash` (`token_hash`),
+    KEY `idx_password_reset_token_user` (`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE todo ADD CONSTRAINT `todo_ibfk_1` FOREIGN KEY(`userID`) REFERENCES user(`id`);
@@ -0,0 +1,2 @@
+-- the key was created unnamed, so MySQL called it todo_ibfk_1
+ALTER TABLE todo DROP FOREIGN KEY `todo_ibfk_1`;
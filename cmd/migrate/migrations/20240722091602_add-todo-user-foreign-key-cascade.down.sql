@@ -0,0 +1 @@
+ALTER TABLE todo DROP FOREIGN KEY `fk_todo_user`;
@@ -0,0 +1 @@
+ALTER TABLE todo ADD CONSTRAINT `fk_todo_user` FOREIGN KEY(`userID`) REFERENCES user(`id`) ON DELETE CASCADE;
@@ -0,0 +1 @@
+-- the original spelling of normalized emails is not kept
@@ -0,0 +1,2 @@
+-- emails are stored trimmed and lower case so lookups need no LOWER()
+UPDATE user SET `email` = LOWER(TRIM(`email`)) WHERE `email` <> LOWER(TRIM(`email`));
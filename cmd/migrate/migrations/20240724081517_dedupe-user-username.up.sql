@@ -0,0 +1,9 @@
+-- the oldest account keeps a username shared regardless of case, the others get their ID appended
+UPDATE user u
+JOIN (
+    SELECT LOWER(`username`) AS `name`, MIN(`id`) AS `keep_id`
+    FROM user
+    GROUP BY LOWER(`username`)
+    HAVING COUNT(*) > 1
+) d ON LOWER(u.`username`) = d.`name` AND u.`id` <> d.`keep_id`
+SET u.`username` = CONCAT(u.`username`, '-', u.`id`);
@@ -0,0 +1 @@
+DROP INDEX `unique_username` ON user;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX `unique_username` ON user ((LOWER(`username`)));
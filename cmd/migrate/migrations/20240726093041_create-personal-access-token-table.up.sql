@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS personal_access_token (
+    `id` INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` INT UNSIGNED NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `token_hash` CHAR(64) NOT NULL,
+    `scopes` VARCHAR(255) NOT NULL,
+    `expires_at` TIMESTAMP NULL DEFAULT NULL,
+    `last_used_at` TIMESTAMP NULL DEFAULT NULL,
+    `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY `unique_personal_access_token_hash` (`token_hash`),
+    KEY `idx_personal_access_token_user` (`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
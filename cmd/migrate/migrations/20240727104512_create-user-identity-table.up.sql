@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_identity (
+    `id` INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `user_id` INT UNSIGNED NOT NULL,
+    `issuer` VARCHAR(255) NOT NULL,
+    `subject` VARCHAR(255) NOT NULL,
+    `email` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `last_login_at` TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE KEY `unique_user_identity_subject` (`issuer`, `subject`),
+    KEY `idx_user_identity_user` (`user_id`),
+    FOREIGN KEY(`user_id`) REFERENCES user(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE user DROP COLUMN `totp_secret`, DROP COLUMN `totp_enabled_at`, DROP COLUMN `totp_last_step`;
@@ -0,0 +1 @@
+ALTER TABLE user_token_cutoff MODIFY `revoked_before` TIMESTAMP(3) NOT NULL;
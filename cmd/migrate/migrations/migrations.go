@@ -0,0 +1,8 @@
+// Package migrations embeds the MySQL schema migrations so the server can
+// tell which version the database is expected to be at.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
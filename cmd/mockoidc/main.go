@@ -0,0 +1,46 @@
+// Command mockoidc runs a local OpenID Connect provider to try single sign-on
+// against without a real identity provider. Point OIDC_ISSUER at it:
+//
+//	go run ./cmd/mockoidc
+//	OIDC_ISSUER=http://localhost:9090 OIDC_CLIENT_ID=todo OIDC_CLIENT_SECRET=secret go run ./cmd
+//
+// Every login is approved; add login_hint=someone@example.com to
+// /api/v1/oidc/login to choose who signs in. Addresses listed, comma
+// separated, in MOCK_OIDC_UNVERIFIED_EMAILS are not claimed to be verified.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Waris-Shaik/todo/oidc"
+)
+
+func main() {
+	addr := getEnv("MOCK_OIDC_ADDR", ":9090")
+	issuer := getEnv("MOCK_OIDC_ISSUER", "http://localhost"+addr[strings.LastIndex(addr, ":"):])
+
+	provider, err := oidc.NewMockProvider(issuer, getEnv("MOCK_OIDC_CLIENT_ID", "todo"), getEnv("MOCK_OIDC_CLIENT_SECRET", "secret"))
+	if err != nil {
+		log.Fatalf("could not create provider: %v", err)
+	}
+	for _, email := range strings.Split(os.Getenv("MOCK_OIDC_UNVERIFIED_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			provider.SetEmailVerified(email, false)
+		}
+	}
+
+	log.Printf("mock OIDC provider for %s listening on %s", issuer, addr)
+	if err := http.ListenAndServe(addr, provider); err != nil {
+		log.Fatalf("could not start server: %v", err)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
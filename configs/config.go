@@ -4,17 +4,110 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Waris-Shaik/todo/ratelimit"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 	Port       string
+	DBDriver   string
 	DBUser     string
 	DBPassword string
 	DBAddress  string
 	DBPort     string
 	DBName     string
+	SQLitePath string
+	// DBQueryTimeout bounds each database call; zero disables the limit.
+	DBQueryTimeout time.Duration
+
+	// HTTP server timeouts and how long shutdown waits for in-flight requests
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	ShutdownTimeout  time.Duration
+
+	// LogFormat is json or text, LogLevel one of debug, info, warn or error
+	LogFormat string
+	LogLevel  string
+
+	// rate limits as "requests/period", e.g. 10/1m; 0/1m disables a limit
+	LoginIPRate      ratelimit.Rate
+	LoginAccountRate ratelimit.Rate
+	RegisterIPRate   ratelimit.Rate
+	// TrustProxy takes client IPs from X-Forwarded-For, set it behind Render
+	TrustProxy bool
+
+	// accounts lock after LockoutThreshold wrong passwords in a row, for
+	// LockoutBase doubling with every further failure up to LockoutMax
+	LockoutThreshold int
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+
+	// AppURL is the public address links in emails point to
+	AppURL string
+	// PasswordResetURL is the frontend page reset links open
+	PasswordResetURL   string
+	PasswordForgotRate ratelimit.Rate
+	// Mailer is log, file or smtp; file writes every message to MailDir
+	Mailer       string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// VerifiedEmailRoutes names the routes unverified users may not call
+	VerifiedEmailRoutes   []string
+	VerificationEmailRate ratelimit.Rate
+
+	// deleted accounts are purged AccountDeletionGrace after deletion, checked
+	// every AccountPurgeInterval; zero disables purging
+	AccountDeletionGrace time.Duration
+	AccountPurgeInterval time.Duration
+
+	// password policy; PasswordBreachedList is a file of breached passwords
+	// or their SHA-1 hashes, empty to skip that check
+	PasswordMinLength        int
+	PasswordMaxLength        int
+	PasswordMinClasses       int
+	PasswordMinScore         int
+	PasswordDisallowPersonal bool
+	PasswordBreachedList     string
+
+	// access tokens are signed with JWTAlgorithm: HS256 with JWTSecretKey, or
+	// RS256 or EdDSA with the key in JWTPrivateKeyFile. The previous secrets
+	// and public keys only verify, so keys can rotate without logging anyone out.
+	JWTAlgorithm          string
+	JWTSecretKey          string
+	JWTPreviousSecretKeys []string
+	JWTPrivateKeyFile     string
+	JWTPublicKeyFiles     []string
+	JWTIssuer             string
+	JWTAudience           string
+	// RevocationCacheTTL is how long a "not revoked" answer is trusted before
+	// asking the store again, so revocations made by other instances take up
+	// to this long to apply. Zero asks on every request.
+	RevocationCacheTTL time.Duration
+
+	// single sign-on with an OpenID Connect provider is on when OIDCIssuer is
+	// set. Without OIDCClientSecret the client is public and relies on PKCE.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCPostLoginURL string
+
+	// TOTP secrets are encrypted with MFAEncryptionKey, a base64 encoded 32
+	// byte key; two-factor authentication is off without one. The previous
+	// keys only decrypt, so the key can rotate.
+	MFAEncryptionKey          string
+	MFAPreviousEncryptionKeys []string
+	MFAIssuer                 string
 }
 
 var Envs Config
@@ -28,49 +121,266 @@ func initConfig() Config {
 	godotenv.Load()
 
 	port := os.Getenv("PORT")
+	dbDriver := os.Getenv("DB_DRIVER")
 	dbUser := os.Getenv("DB_USER")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbAddress := os.Getenv("DB_ADDRESS")
 	dbPort := os.Getenv("DB_PORT")
 	dbName := os.Getenv("DB_NAME")
+	sqlitePath := os.Getenv("SQLITE_PATH")
 	jwtSecretKey := os.Getenv("JWT_SECRET_KEY")
 	nodeEnv := os.Getenv("NODE_ENV")
+	logFormat := os.Getenv("LOG_FORMAT")
+	logLevel := os.Getenv("LOG_LEVEL")
+	appURL := os.Getenv("APP_URL")
+	mailerKind := os.Getenv("MAILER")
+	mailFrom := os.Getenv("MAIL_FROM")
+	mailDir := os.Getenv("MAIL_DIR")
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
 
 	if port == "" {
 		log.Fatal("error: PORT environment variable is not set ")
 	}
-	if dbUser == "" {
-		log.Fatal("error: DB_USER environment variable is not set")
-	}
-	if dbPassword == "" {
-		log.Fatal("error: DB_PASSWORD environment variable is not set")
-	}
-	if dbAddress == "" {
-		log.Fatal("error: DB_ADDRESS environment variable is not set")
-	}
-	if dbPort == "" {
-		log.Fatal("error: DB_PORT environment variable is not set")
+
+	if dbDriver == "" {
+		dbDriver = "mysql"
 	}
-	if dbName == "" {
-		log.Fatal("error: DB_NAME environment variable is not set")
+
+	switch dbDriver {
+	case "mysql":
+		if dbUser == "" {
+			log.Fatal("error: DB_USER environment variable is not set")
+		}
+		if dbPassword == "" {
+			log.Fatal("error: DB_PASSWORD environment variable is not set")
+		}
+		if dbAddress == "" {
+			log.Fatal("error: DB_ADDRESS environment variable is not set")
+		}
+		if dbPort == "" {
+			log.Fatal("error: DB_PORT environment variable is not set")
+		}
+		if dbName == "" {
+			log.Fatal("error: DB_NAME environment variable is not set")
+		}
+	case "sqlite":
+		if sqlitePath == "" {
+			sqlitePath = "todo.db"
+		}
+	case "memory":
+	default:
+		log.Fatalf("error: DB_DRIVER must be one of mysql, sqlite or memory, got %q", dbDriver)
 	}
 
 	if jwtSecretKey == "" {
 		log.Fatal("error: JWT_SECRET_KEY environment variable is not set")
 	}
 
+	oidcIssuer := os.Getenv("OIDC_ISSUER")
+	oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+	if oidcIssuer != "" && oidcClientID == "" {
+		log.Fatal("error: OIDC_CLIENT_ID must be set along with OIDC_ISSUER")
+	}
+
 	if nodeEnv == "" {
 		log.Fatal("error: NODE_ENV environment variable is not set")
 	}
 
+	if logFormat == "" {
+		logFormat = "json"
+	}
+	if logFormat != "json" && logFormat != "text" {
+		log.Fatalf("error: LOG_FORMAT must be json or text, got %q", logFormat)
+	}
+
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	switch logLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		log.Fatalf("error: LOG_LEVEL must be one of debug, info, warn or error, got %q", logLevel)
+	}
+
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+	appURL = strings.TrimSuffix(appURL, "/")
+
+	if mailerKind == "" {
+		mailerKind = "log"
+	}
+	switch mailerKind {
+	case "log":
+	case "file":
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+	case "smtp":
+		if smtpHost == "" {
+			log.Fatal("error: SMTP_HOST environment variable is not set")
+		}
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+	default:
+		log.Fatalf("error: MAILER must be one of log, file or smtp, got %q", mailerKind)
+	}
+	if mailFrom == "" {
+		mailFrom = "Todo <no-reply@localhost>"
+	}
+
 	dbAddress = fmt.Sprintf("%s:%s", dbAddress, dbPort)
 
 	return Config{
 		Port:       port,
+		DBDriver:   dbDriver,
 		DBUser:     dbUser,
 		DBPassword: dbPassword,
 		DBAddress:  dbAddress,
 		DBPort:     dbPort,
 		DBName:     dbName,
+		SQLitePath: sqlitePath,
+
+		DBQueryTimeout: getDuration("DB_QUERY_TIMEOUT", 5*time.Second),
+
+		HTTPReadTimeout:  getDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		HTTPWriteTimeout: getDuration("HTTP_WRITE_TIMEOUT", 15*time.Second),
+		HTTPIdleTimeout:  getDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:  getDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
+
+		LogFormat: logFormat,
+		LogLevel:  logLevel,
+
+		LoginIPRate:      getRate("RATE_LIMIT_LOGIN_IP", "20/1m"),
+		LoginAccountRate: getRate("RATE_LIMIT_LOGIN_ACCOUNT", "5/15m"),
+		RegisterIPRate:   getRate("RATE_LIMIT_REGISTER_IP", "5/1h"),
+		TrustProxy:       getBool("TRUST_PROXY", false),
+
+		LockoutThreshold: getInt("LOCKOUT_THRESHOLD", 5),
+		LockoutBase:      getDuration("LOCKOUT_BASE", time.Minute),
+		LockoutMax:       getDuration("LOCKOUT_MAX", time.Hour),
+
+		AppURL:             appURL,
+		PasswordResetURL:   getString("PASSWORD_RESET_URL", appURL+"/reset-password"),
+		PasswordForgotRate: getRate("RATE_LIMIT_PASSWORD_FORGOT", "5/1h"),
+		Mailer:             mailerKind,
+		MailFrom:           mailFrom,
+		MailDir:            mailDir,
+		SMTPHost:           smtpHost,
+		SMTPPort:           smtpPort,
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+
+		VerifiedEmailRoutes:   getList("VERIFIED_EMAIL_ROUTES", "todos.create,todos.update,todos.delete"),
+		VerificationEmailRate: getRate("RATE_LIMIT_VERIFICATION_EMAIL", "3/1h"),
+
+		AccountDeletionGrace: getDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+		AccountPurgeInterval: getDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+
+		PasswordMinLength:        getInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:        getInt("PASSWORD_MAX_LENGTH", 72),
+		PasswordMinClasses:       getInt("PASSWORD_MIN_CLASSES", 1),
+		PasswordMinScore:         getInt("PASSWORD_MIN_SCORE", 2),
+		PasswordDisallowPersonal: getBool("PASSWORD_DISALLOW_PERSONAL", true),
+		PasswordBreachedList:     os.Getenv("PASSWORD_BREACHED_LIST"),
+
+		JWTAlgorithm:          getString("JWT_ALGORITHM", "HS256"),
+		JWTSecretKey:          jwtSecretKey,
+		JWTPreviousSecretKeys: getList("JWT_PREVIOUS_SECRET_KEYS", "none"),
+		JWTPrivateKeyFile:     os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		JWTPublicKeyFiles:     getList("JWT_PUBLIC_KEY_FILES", "none"),
+		JWTIssuer:             getString("JWT_ISSUER", appURL),
+		JWTAudience:           getString("JWT_AUDIENCE", "todo-api"),
+		RevocationCacheTTL:    getDuration("REVOCATION_CACHE_TTL", 30*time.Second),
+
+		OIDCIssuer:       oidcIssuer,
+		OIDCClientID:     oidcClientID,
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  getString("OIDC_REDIRECT_URL", appURL+"/api/v1/oidc/callback"),
+		OIDCScopes:       getList("OIDC_SCOPES", "openid,email,profile"),
+		OIDCPostLoginURL: os.Getenv("OIDC_POST_LOGIN_URL"),
+
+		MFAEncryptionKey:          os.Getenv("MFA_ENCRYPTION_KEY"),
+		MFAPreviousEncryptionKeys: getList("MFA_PREVIOUS_ENCRYPTION_KEYS", "none"),
+		MFAIssuer:                 getString("MFA_ISSUER", "Todo"),
+	}
+}
+
+// getDuration reads a duration such as "5s" from the environment, falling back
+// when it is unset.
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Fatalf("error: %s must be a duration such as 5s, got %q", key, value)
+	}
+	return parsed
+}
+
+// getRate reads a rate limit such as "10/1m" from the environment.
+func getRate(key, fallback string) ratelimit.Rate {
+	value := os.Getenv(key)
+	if value == "" {
+		value = fallback
+	}
+	rate, err := ratelimit.ParseRate(value)
+	if err != nil {
+		log.Fatalf("error: %s: %v", key, err)
+	}
+	return rate
+}
+
+func getString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getList reads a comma separated list; set the variable to "none" for an
+// empty one.
+func getList(key, fallback string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		value = fallback
+	}
+	if value == "none" {
+		return nil
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		log.Fatalf("error: %s must be a non-negative integer, got %q", key, value)
+	}
+	return parsed
+}
+
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("error: %s must be true or false, got %q", key, value)
 	}
+	return parsed
 }
@@ -1,11 +1,25 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Waris-Shaik/todo/types"
 	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "modernc.org/sqlite"
 )
 
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
 func MyNewSQLStorage(cfg mysql.Config) (*sql.DB, error) {
 	db, err := sql.Open("mysql", cfg.FormatDSN())
 	if err != nil {
@@ -13,3 +27,92 @@ func MyNewSQLStorage(cfg mysql.Config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// NewSQLiteStorage opens the SQLite database at path (":memory:" for a
+// throwaway one) and brings its schema up to date, so local runs and tests
+// need no separate migration step.
+func NewSQLiteStorage(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// every connection to ":memory:" is a separate database
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	source, err := iofs.New(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		return fmt.Errorf("error reading sqlite migrations: %w", err)
+	}
+
+	driver, err := migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("error creating database driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return fmt.Errorf("error creating migration instance: %w", err)
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("error applying migrations: %w", err)
+	}
+	return nil
+}
+
+// IsDuplicateKey reports whether err is a unique constraint violation from
+// either MySQL or SQLite.
+func IsDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// QueryTimeout is the deadline applied to every store call. Zero disables it.
+var QueryTimeout = 5 * time.Second
+
+var (
+	ErrTimeout  = &types.Error{Kind: types.ErrTimeout, Code: "database_timeout", Message: "database query timed out"}
+	ErrCanceled = &types.Error{Kind: types.ErrUnavailable, Code: "request_canceled", Message: "request was canceled"}
+)
+
+// WithTimeout derives the context a single store call runs under.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, QueryTimeout)
+}
+
+// Error converts a failed query into the error a store returns: ErrTimeout or
+// ErrCanceled when ctx ended first, otherwise an internal error so driver
+// details do not leak to clients.
+func Error(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled):
+		return ErrCanceled
+	default:
+		return types.Internal(err)
+	}
+}
+
+// IsUnavailable reports whether err means the database could not answer in
+// time, as opposed to the query itself failing.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, types.ErrTimeout) || errors.Is(err, types.ErrUnavailable)
+}
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS todo (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `title` TEXT NOT NULL,
+    `description` TEXT DEFAULT NULL,
+    `status` TEXT NOT NULL DEFAULT 'pending' CHECK (`status` IN ('pending', 'completed')),
+    `userID` INTEGER NOT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    `updated_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    FOREIGN KEY(`userID`) REFERENCES `user`(`id`)
+);
+
+CREATE TRIGGER IF NOT EXISTS `todo_updated_at` AFTER UPDATE ON todo
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE todo SET updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now') WHERE id = NEW.id;
+END;
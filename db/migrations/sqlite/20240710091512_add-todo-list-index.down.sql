@@ -0,0 +1 @@
+DROP INDEX IF EXISTS `idx_todo_user_created`;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS refresh_token (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `user_id` INTEGER NOT NULL,
+    `family_id` CHAR(32) NOT NULL,
+    `token_hash` CHAR(64) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `used_at` TIMESTAMP NULL DEFAULT NULL,
+    `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    CONSTRAINT `unique_token_hash` UNIQUE (`token_hash`),
+    FOREIGN KEY(`user_id`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_refresh_token_family` ON refresh_token (`family_id`);
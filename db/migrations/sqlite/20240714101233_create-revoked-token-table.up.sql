@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS revoked_token (
+    `jti` CHAR(32) PRIMARY KEY,
+    `user_id` INTEGER NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `revoked_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    FOREIGN KEY(`user_id`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_revoked_token_expires` ON revoked_token (`expires_at`);
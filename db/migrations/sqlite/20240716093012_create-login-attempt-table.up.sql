@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_attempt (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `user_id` INTEGER NULL DEFAULT NULL,
+    `identifier` VARCHAR(255) NOT NULL,
+    `ip` VARCHAR(45) NOT NULL,
+    `user_agent` VARCHAR(512) NOT NULL DEFAULT '',
+    `success` BOOLEAN NOT NULL,
+    `reason` VARCHAR(32) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    FOREIGN KEY(`user_id`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_login_attempt_user` ON login_attempt (`user_id`, `id`);
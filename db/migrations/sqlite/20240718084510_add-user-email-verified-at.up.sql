@@ -0,0 +1 @@
+ALTER TABLE `user` ADD COLUMN `email_verified_at` TIMESTAMP NULL DEFAULT NULL;
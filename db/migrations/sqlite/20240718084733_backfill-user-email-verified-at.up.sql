@@ -0,0 +1,2 @@
+-- accounts created before verification existed are treated as verified
+UPDATE `user` SET `email_verified_at` = `created_at` WHERE `email_verified_at` IS NULL;
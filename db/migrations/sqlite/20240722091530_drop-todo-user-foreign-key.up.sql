@@ -0,0 +1 @@
+-- SQLite cannot alter a foreign key; the next migration rebuilds the table instead
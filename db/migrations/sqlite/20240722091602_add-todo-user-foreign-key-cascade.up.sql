@@ -0,0 +1,25 @@
+CREATE TABLE todo_new (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `title` TEXT NOT NULL,
+    `description` TEXT DEFAULT NULL,
+    `status` TEXT NOT NULL DEFAULT 'pending' CHECK (`status` IN ('pending', 'completed')),
+    `userID` INTEGER NOT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    `updated_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    FOREIGN KEY(`userID`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+INSERT INTO todo_new (`id`, `title`, `description`, `status`, `userID`, `created_at`, `updated_at`)
+SELECT `id`, `title`, `description`, `status`, `userID`, `created_at`, `updated_at` FROM todo;
+
+DROP TABLE todo;
+
+ALTER TABLE todo_new RENAME TO todo;
+
+CREATE INDEX IF NOT EXISTS `idx_todo_user_created` ON todo (`userID`, `created_at`);
+
+CREATE TRIGGER IF NOT EXISTS `todo_updated_at` AFTER UPDATE ON todo
+FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE todo SET updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now') WHERE id = NEW.id;
+END;
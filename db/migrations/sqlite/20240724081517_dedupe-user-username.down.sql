@@ -0,0 +1 @@
+-- renamed usernames are not restored
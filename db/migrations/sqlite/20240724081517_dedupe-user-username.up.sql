@@ -0,0 +1,3 @@
+-- the oldest account keeps a username shared regardless of case, the others get their ID appended
+UPDATE `user` SET `username` = `username` || '-' || `id`
+WHERE `id` NOT IN (SELECT MIN(`id`) FROM `user` GROUP BY LOWER(`username`));
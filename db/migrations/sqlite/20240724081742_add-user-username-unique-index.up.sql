@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS `unique_username` ON `user` (LOWER(`username`));
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS personal_access_token (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `user_id` INTEGER NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `token_hash` CHAR(64) NOT NULL,
+    `scopes` VARCHAR(255) NOT NULL,
+    `expires_at` TIMESTAMP NULL DEFAULT NULL,
+    `last_used_at` TIMESTAMP NULL DEFAULT NULL,
+    `revoked_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    CONSTRAINT `unique_personal_access_token_hash` UNIQUE (`token_hash`),
+    FOREIGN KEY(`user_id`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_personal_access_token_user` ON personal_access_token (`user_id`);
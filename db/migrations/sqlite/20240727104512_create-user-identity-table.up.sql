@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_identity (
+    `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+    `user_id` INTEGER NOT NULL,
+    `issuer` VARCHAR(255) NOT NULL,
+    `subject` VARCHAR(255) NOT NULL,
+    `email` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')),
+    `last_login_at` TIMESTAMP NULL DEFAULT NULL,
+    CONSTRAINT `unique_user_identity_subject` UNIQUE (`issuer`, `subject`),
+    FOREIGN KEY(`user_id`) REFERENCES `user`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS `idx_user_identity_user` ON user_identity (`user_id`);
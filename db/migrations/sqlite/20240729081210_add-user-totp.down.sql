@@ -0,0 +1,3 @@
+ALTER TABLE `user` DROP COLUMN `totp_last_step`;
+ALTER TABLE `user` DROP COLUMN `totp_enabled_at`;
+ALTER TABLE `user` DROP COLUMN `totp_secret`;
@@ -0,0 +1,3 @@
+ALTER TABLE `user` ADD COLUMN `totp_secret` VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE `user` ADD COLUMN `totp_enabled_at` TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE `user` ADD COLUMN `totp_last_step` BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+-- SQLite keeps the fractional seconds of TIMESTAMP values already
@@ -0,0 +1,123 @@
+// Package metrics collects Prometheus metrics for the API and serves them at
+// /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Waris-Shaik/todo/utils"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "todo"
+
+// Registry holds every metric the service exports. It is separate from the
+// prometheus default registry so nothing gets exported by accident.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	responseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_response_size_bytes",
+		Help:      "Size of HTTP response bodies, by method and route template.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"method", "route"})
+
+	// TodosCreated counts todos created through the API.
+	TodosCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "todos_created_total",
+		Help:      "Todos created.",
+	})
+
+	// Logins counts login attempts by result, either "success" or "failure".
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		responseSize,
+		TodosCreated,
+		Logins,
+	)
+
+	// export both login results from the start so rates work before the first failure
+	Logins.WithLabelValues("success")
+	Logins.WithLabelValues("failure")
+}
+
+// LoginSucceeded and LoginFailed record the outcome of a login attempt.
+func LoginSucceeded() { Logins.WithLabelValues("success").Inc() }
+func LoginFailed()    { Logins.WithLabelValues("failure").Inc() }
+
+// RegisterDB exports the connection pool stats of db. Registering the same
+// pool twice is a no-op.
+func RegisterDB(db *sql.DB, name string) {
+	err := Registry.Register(collectors.NewDBStatsCollector(db, name))
+	var already prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &already) {
+		slog.Error("registering database metrics failed", "err", err)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Instrument wraps router and records every request under the template of
+// the route it matched, e.g. /api/v1/todos/{id}, so IDs do not blow up the
+// number of series.
+func Instrument(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(router, r)
+
+		start := time.Now()
+		rec := utils.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		requestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(rec.Status)).Inc()
+		requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		responseSize.WithLabelValues(r.Method, route).Observe(float64(rec.Size))
+	})
+}
+
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	// keep unmatched paths out of the labels
+	return "unmatched"
+}
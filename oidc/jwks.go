@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// jwk is a public key from the provider's JSON Web Key Set.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// keySet is the provider's signing keys by ID.
+type keySet struct {
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+// minRefetchInterval keeps tokens with made up key IDs from making us fetch
+// the provider's keys on every callback.
+const minRefetchInterval = time.Minute
+
+// key returns the provider key with ID kid, refetching the key set when kid is
+// unknown in case the provider rotated its keys.
+func (p *Provider) key(ctx context.Context, d *discovery, kid, alg string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || (p.keys.keys[kid] == nil && time.Since(p.keys.fetchedAt) >= minRefetchInterval) {
+		keys, err := p.fetchKeys(ctx, d.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = keys
+	}
+
+	key := p.keys.keys[kid]
+	// without a kid the provider must have a single key
+	if kid == "" && len(p.keys.keys) == 1 {
+		for _, only := range p.keys.keys {
+			key = only
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+
+	// a token must not pick an algorithm other than its key's
+	ok := false
+	switch key.(type) {
+	case *rsa.PublicKey:
+		ok = alg[0] == 'R' || alg[0] == 'P'
+	case *ecdsa.PublicKey:
+		ok = alg[0] == 'E' && alg != "EdDSA"
+	case ed25519.PublicKey:
+		ok = alg == "EdDSA"
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %q cannot verify %s", kid, alg)
+	}
+	return key, nil
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (*keySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := p.doJSON(req, &document)
+	if err != nil {
+		return nil, fmt.Errorf("fetching provider keys: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching provider keys: status %d", status)
+	}
+
+	keys := &keySet{keys: make(map[string]any), fetchedAt: time.Now()}
+	for _, k := range document.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// keys of unknown types are skipped rather than failing the set, as
+		// providers may publish keys we never need
+		if key, err := k.publicKey(); err == nil {
+			keys.keys[k.KeyID] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on %s", k.Curve)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,319 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MockProvider is a minimal OpenID Connect provider for local development and
+// manual testing. It signs in whoever asks without a login page: the email
+// comes from the login_hint parameter, so one provider can stand in for many
+// users. It must never be exposed to the internet.
+type MockProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	keyID        string
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+	// unverified are the emails ID tokens do not claim are verified
+	unverified map[string]bool
+}
+
+// mockCode is an issued authorization code waiting to be exchanged.
+type mockCode struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	expiresAt   time.Time
+}
+
+// mockCodeLifetime is how long an authorization code can be exchanged for.
+const mockCodeLifetime = time.Minute
+
+// NewMockProvider returns a provider for issuer that accepts a single client.
+// An empty clientSecret makes it a public client that relies on PKCE alone.
+func NewMockProvider(issuer, clientID, clientSecret string) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &MockProvider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		keyID:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		codes:        make(map[string]mockCode),
+		unverified:   make(map[string]bool),
+	}, nil
+}
+
+// SetEmailVerified sets whether ID tokens for email say the address is
+// verified. Every address is verified unless set otherwise.
+func (m *MockProvider) SetEmailVerified(email string, verified bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if verified {
+		delete(m.unverified, strings.ToLower(email))
+	} else {
+		m.unverified[strings.ToLower(email)] = true
+	}
+}
+
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		m.handleDiscovery(w, r)
+	case "/authorize":
+		m.handleAuthorize(w, r)
+	case "/token":
+		m.handleToken(w, r)
+	case "/jwks":
+		m.handleJWKS(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *MockProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+// handleAuthorize approves every valid request at once and sends the user
+// back with a code.
+func (m *MockProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != m.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	// from here on errors go back to the client, as a real provider would
+	fail := func(code, description string) {
+		values := redirectURI.Query()
+		values.Set("error", code)
+		values.Set("error_description", description)
+		values.Set("state", query.Get("state"))
+		redirectURI.RawQuery = values.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+	if query.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	if !strings.Contains(" "+query.Get("scope")+" ", " openid ") {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "an S256 code_challenge is required")
+		return
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		email = "mock.user@example.com"
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.mu.Lock()
+	m.codes[code] = mockCode{
+		clientID:    m.clientID,
+		redirectURI: redirectURI.String(),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		email:       strings.ToLower(email),
+		expiresAt:   time.Now().Add(mockCodeLifetime),
+	}
+	m.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (m *MockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !m.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// codes are single use, so it is removed whether or not the exchange works
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	verified := !m.unverified[code.email]
+	m.mu.Unlock()
+
+	if !ok || time.Now().After(code.expiresAt) {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+	if codeChallenge(r.PostForm.Get("code_verifier")) != code.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	// jane.doe@example.com is Jane Doe with username jane.doe
+	now := time.Now()
+	local, _, _ := strings.Cut(code.email, "@")
+	names := strings.FieldsFunc(local, func(r rune) bool { return r == '.' || r == '_' || r == '-' || r == '+' })
+	for i, name := range names {
+		names[i] = strings.ToUpper(name[:1]) + name[1:]
+	}
+	claims := jwt.MapClaims{
+		"iss":                m.issuer,
+		"sub":                subjectFor(code.email),
+		"aud":                code.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"email":              code.email,
+		"email_verified":     verified,
+		"name":               strings.Join(names, " "),
+		"preferred_username": local,
+	}
+	if len(names) > 0 {
+		claims["given_name"] = names[0]
+		claims["family_name"] = strings.Join(names[1:], " ")
+	}
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.keyID
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// authenticateClient accepts client_secret_basic, client_secret_post, or for
+// a public client just its client_id.
+func (m *MockProvider) authenticateClient(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.clientID {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(m.clientSecret)) == 1
+}
+
+func (m *MockProvider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []jwk{{
+			KeyType:   "RSA",
+			KeyID:     m.keyID,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// subjectFor derives a stable subject from email, so the same user gets the
+// same identity across restarts of the mock.
+func subjectFor(email string) string {
+	sum := sha256.Sum256([]byte("mock|" + email))
+	return base64.RawURLEncoding.EncodeToString(sum[:15])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,65 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BreachedList is a set of passwords known from data breaches, kept as SHA-1
+// hashes so a plain list and a Have I Been Pwned download cost the same.
+type BreachedList struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// LoadBreachedList reads a breached password list with one entry per line,
+// either the password itself or its SHA-1 in hex, optionally followed by
+// ":count" as in the Have I Been Pwned files. Empty lines and lines starting
+// with "#" are skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &BreachedList{hashes: make(map[[sha1.Size]byte]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.hashes[lineHash(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// lineHash returns the SHA-1 a list line stands for.
+func lineHash(line string) [sha1.Size]byte {
+	hexHash, _, _ := strings.Cut(line, ":")
+	var hash [sha1.Size]byte
+	if len(hexHash) == hex.EncodedLen(sha1.Size) {
+		if _, err := hex.Decode(hash[:], []byte(hexHash)); err == nil {
+			return hash
+		}
+	}
+	return sha1.Sum([]byte(line))
+}
+
+// Contains reports whether password is on the list.
+func (l *BreachedList) Contains(password string) bool {
+	_, ok := l.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
+
+// Len returns the number of entries on the list.
+func (l *BreachedList) Len() int {
+	return len(l.hashes)
+}
@@ -0,0 +1,144 @@
+// Package passwordpolicy decides which passwords users may choose: length
+// within bcrypt's limits, a mix of character classes, nothing built from the
+// user's own username or email, a minimum estimated strength and, optionally,
+// not on a list of breached passwords.
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// MaxBytes is the most bcrypt hashes; anything after it would be ignored.
+const MaxBytes = 72
+
+// Policy holds the rules a new password must meet. Zero values disable the
+// corresponding rule, except MaxLength which falls back to MaxBytes.
+type Policy struct {
+	// MinLength counts characters, MaxLength bytes as bcrypt does
+	MinLength int
+	MaxLength int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols
+	// the password must mix
+	MinClasses int
+	// DisallowPersonal rejects passwords containing the username or email
+	DisallowPersonal bool
+	// MinScore is the lowest Strength score accepted, from 0 to 4
+	MinScore int
+	// Breached rejects passwords found in a breach, nil skips the check
+	Breached *BreachedList
+}
+
+// Validate reports settings that cannot work.
+func (p Policy) Validate() error {
+	switch {
+	case p.MinLength < 0:
+		return fmt.Errorf("minimum length must not be negative")
+	case p.MaxLength < 0 || p.MaxLength > MaxBytes:
+		return fmt.Errorf("maximum length must be between 1 and %d", MaxBytes)
+	case p.MaxLength > 0 && p.MinLength > p.MaxLength:
+		return fmt.Errorf("minimum length %d is above the maximum %d", p.MinLength, p.MaxLength)
+	case p.MinClasses < 0 || p.MinClasses > 4:
+		return fmt.Errorf("character classes must be between 0 and 4")
+	case p.MinScore < 0 || p.MinScore > 4:
+		return fmt.Errorf("minimum score must be between 0 and 4")
+	}
+	return nil
+}
+
+// Check returns a validation error listing every rule password breaks, or
+// nil. personal holds the user's own details, such as username and email.
+func (p Policy) Check(password string, personal ...string) error {
+	var details []types.FieldError
+	fail := func(rule, message string) {
+		details = append(details, types.FieldError{Field: "password", Rule: rule, Message: message})
+	}
+
+	maxLength := p.MaxLength
+	if maxLength == 0 {
+		maxLength = MaxBytes
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		fail("min", fmt.Sprintf("password must be at least %d characters", p.MinLength))
+	}
+	if len(password) > maxLength {
+		fail("max", fmt.Sprintf("password must not be longer than %d bytes", maxLength))
+	}
+	if classes(password) < p.MinClasses {
+		fail("classes", fmt.Sprintf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses))
+	}
+	if p.DisallowPersonal && containsPersonal(password, personal) {
+		fail("personal", "password must not contain your username or email")
+	}
+	if p.MinScore > 0 && Strength(password, personal...) < p.MinScore {
+		fail("strength", "password is too easy to guess, try a longer one or a few unrelated words")
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		fail("breached", "password has appeared in a data breach, please choose another one")
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+	return types.InvalidFields(details)
+}
+
+// classes counts the character classes in password: lowercase, uppercase,
+// digits and everything else.
+func classes(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// minPersonalLength keeps short usernames from ruling out most passwords.
+const minPersonalLength = 3
+
+// containsPersonal reports whether password contains any of personal, or the
+// part of an email before the "@", ignoring case.
+func containsPersonal(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range personalWords(personal) {
+		if strings.Contains(password, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// personalWords lower cases personal and adds the local part of emails.
+func personalWords(personal []string) []string {
+	words := make([]string, 0, len(personal)*2)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, ok := strings.Cut(value, "@"); ok && utf8.RuneCountInString(local) >= minPersonalLength {
+			words = append(words, local)
+		}
+		if utf8.RuneCountInString(value) >= minPersonalLength {
+			words = append(words, value)
+		}
+	}
+	return words
+}
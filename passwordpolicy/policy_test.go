@@ -0,0 +1,150 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// failedRules returns the rules err lists, sorted.
+func failedRules(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var typed *types.Error
+	if !errors.As(err, &typed) {
+		t.Fatalf("error = %v, want a *types.Error", err)
+	}
+	var rules []string
+	for _, detail := range typed.Details {
+		if detail.Field != "password" {
+			t.Fatalf("detail %+v is not about the password", detail)
+		}
+		rules = append(rules, detail.Rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestValidate(t *testing.T) {
+	valid := []Policy{
+		{},
+		{MinLength: 8, MaxLength: 72, MinClasses: 4, MinScore: 4},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v, want nil", p, err)
+		}
+	}
+
+	invalid := []Policy{
+		{MinLength: -1},
+		{MaxLength: 73},
+		{MinLength: 20, MaxLength: 10},
+		{MinClasses: 5},
+		{MinScore: 5},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want an error", p)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		want     string
+	}{
+		{name: "ok", policy: Policy{MinLength: 8, MinClasses: 1, MinScore: 2, DisallowPersonal: true}, password: "kitchen-lamp-river"},
+		{name: "short", policy: Policy{MinLength: 8}, password: "abc", want: "min"},
+		// characters, not bytes, count towards the minimum
+		{name: "multibyte", policy: Policy{MinLength: 4}, password: "ééé", want: "min"},
+		{name: "over bcrypt limit", policy: Policy{}, password: strings.Repeat("a", 73), want: "max"},
+		{name: "over max length", policy: Policy{MaxLength: 10}, password: "kitchen-lamp", want: "max"},
+		{name: "classes", policy: Policy{MinClasses: 3}, password: "kitchenlamp", want: "classes"},
+		{name: "username", policy: Policy{DisallowPersonal: true}, password: "xxJANExx", want: "personal"},
+		{name: "email local part", policy: Policy{DisallowPersonal: true}, password: "doe.jane-42", want: "personal"},
+		{name: "weak", policy: Policy{MinScore: 2}, password: "password", want: "strength"},
+		{name: "keyboard run", policy: Policy{MinScore: 2}, password: "qwertyuiop", want: "strength"},
+		{name: "every failure", policy: Policy{MinLength: 12, MinClasses: 3, MinScore: 2, DisallowPersonal: true}, password: "jane", want: "classes min personal strength"},
+	}
+	for _, tt := range tests {
+		err := tt.policy.Check(tt.password, "jane", "doe.jane@example.com")
+		if got := strings.Join(failedRules(t, err), " "); got != tt.want {
+			t.Errorf("%s: Check(%q) failed rules %q, want %q", tt.name, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestShortPersonalDetailsAreIgnored(t *testing.T) {
+	p := Policy{DisallowPersonal: true}
+	// a two letter username would otherwise rule out most passwords
+	if err := p.Check("kitchen-lamp-river", "ki", "ri@example.com"); err != nil {
+		t.Fatalf("Check = %v, want short personal details ignored", err)
+	}
+}
+
+func TestStrength(t *testing.T) {
+	for _, password := range []string{"", "password", "aaaaaaaaaaaa", "12345678", "abcdefgh", "qwertyuiop"} {
+		if score := Strength(password); score > 1 {
+			t.Errorf("Strength(%q) = %d, want at most 1", password, score)
+		}
+	}
+	for _, password := range []string{"kitchen-lamp-river", "correct horse battery staple", "Tr0ub4dor&3"} {
+		if score := Strength(password); score < 3 {
+			t.Errorf("Strength(%q) = %d, want at least 3", password, score)
+		}
+	}
+	if Strength("janedoe1985", "janedoe") >= Strength("janedoe1985") {
+		t.Error("the user's own details did not lower the score")
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	lines := []string{
+		"# comment",
+		"",
+		"hunter2",
+		// SHA-1 of "kitchen-lamp-river" as in the Have I Been Pwned files
+		"9597191E8F67B69934D46D286497A12179400830:12",
+		"letmein\r",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedList: %v", err)
+	}
+	if list.Len() != 3 {
+		t.Fatalf("Len = %d, want 3 entries without the comment and blank line", list.Len())
+	}
+	for _, password := range []string{"hunter2", "letmein", "kitchen-lamp-river"} {
+		if !list.Contains(password) {
+			t.Errorf("Contains(%q) = false, want true", password)
+		}
+	}
+	if list.Contains("Hunter2") {
+		t.Error("Contains ignored case, breached passwords are case sensitive")
+	}
+
+	p := Policy{Breached: list}
+	if got := failedRules(t, p.Check("hunter2")); len(got) != 1 || got[0] != "breached" {
+		t.Errorf("Check of a breached password failed %v, want breached", got)
+	}
+
+	if _, err := LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("LoadBreachedList of a missing file = nil error")
+	}
+}
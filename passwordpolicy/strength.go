@@ -0,0 +1,159 @@
+package passwordpolicy
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength estimates how hard password is to guess on zxcvbn's scale:
+//
+//	0 too guessable, under 10^3 guesses
+//	1 very guessable, under 10^6
+//	2 somewhat guessable, under 10^8
+//	3 safely unguessable, under 10^10
+//	4 very unguessable
+//
+// Like zxcvbn it charges little for common passwords and words, userInputs,
+// repeated characters, sequences such as "abc" or "321" and keyboard runs such
+// as "qwerty", and the full alphabet size for every other character. It has a
+// much smaller dictionary, so treat it as a floor rather than a precise score.
+func Strength(password string, userInputs ...string) int {
+	bits := entropy(password, userInputs)
+	switch {
+	case bits < 10:
+		return 0
+	case bits < 20:
+		return 1
+	case bits < 26.6:
+		return 2
+	case bits < 33.2:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// entropy returns the estimated guesses for password as bits.
+func entropy(password string, userInputs []string) float64 {
+	runes := []rune(strings.ToLower(password))
+	if len(runes) == 0 {
+		return 0
+	}
+
+	// dictionary words cost one guess out of the dictionary each, and the
+	// characters they cover nothing more
+	words := append(personalWords(userInputs), commonWords...)
+	wordBits := math.Log2(float64(len(words))) + 1 // +1 for capitalization
+	covered := make([]bool, len(runes))
+	var bits float64
+	for _, word := range words {
+		w := []rune(word)
+		if len(w) < minPersonalLength {
+			continue
+		}
+		for i := 0; i+len(w) <= len(runes); i++ {
+			if covered[i] || !equalRunes(runes[i:i+len(w)], w) {
+				continue
+			}
+			for j := i; j < i+len(w); j++ {
+				covered[j] = true
+			}
+			bits += wordBits
+		}
+	}
+
+	charBits := math.Log2(float64(alphabetSize(password)))
+	for i, r := range runes {
+		if covered[i] {
+			continue
+		}
+		if i > 0 && !covered[i-1] && predictable(runes[i-1], r) {
+			bits++
+			continue
+		}
+		bits += charBits
+	}
+	return bits
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// alphabetSize is the size of the smallest alphabet a brute force attack
+// would need to cover every character class in password.
+func alphabetSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return size
+}
+
+// keyboardRows are the runs attackers try, in both directions.
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// predictable reports whether r follows prev as a repeat, an alphabetic or
+// numeric sequence, or a step along a keyboard row.
+func predictable(prev, r rune) bool {
+	if r == prev || r == prev+1 || r == prev-1 {
+		return true
+	}
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, prev)
+		if i < 0 {
+			continue
+		}
+		if (i > 0 && rune(row[i-1]) == r) || (i+1 < len(row) && rune(row[i+1]) == r) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonWords are among the most used passwords and password words, in lower
+// case. Passwords built around them are guessed first.
+var commonWords = []string{
+	"password", "passwort", "passw0rd", "pass", "secret", "letmein", "welcome",
+	"admin", "administrator", "login", "root", "user", "guest", "default",
+	"qwerty", "azerty", "asdf", "zxcv", "abc123", "iloveyou", "love", "lovely",
+	"monkey", "dragon", "master", "shadow", "sunshine", "princess", "football",
+	"baseball", "soccer", "hockey", "superman", "batman", "starwars", "pokemon",
+	"trustno1", "whatever", "freedom", "hello", "charlie", "michael", "jordan",
+	"jennifer", "thomas", "robert", "daniel", "jessica", "ashley", "hunter",
+	"ranger", "buster", "tigger", "ginger", "pepper", "cookie", "cheese",
+	"summer", "winter", "spring", "autumn", "monday", "friday", "sunday",
+	"january", "july", "august", "september", "october", "november", "december",
+	"computer", "internet", "google", "apple", "samsung", "killer", "matrix",
+	"mustang", "corvette", "ferrari", "harley", "yankees", "liverpool",
+	"chelsea", "arsenal", "flower", "angel", "baby", "blessed", "jesus",
+	"family", "forever", "happy", "money", "change", "changeme", "access",
+	"secure", "test", "testing", "temp", "todo", "example", "company",
+}
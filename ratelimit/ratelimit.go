@@ -0,0 +1,140 @@
+// Package ratelimit throttles requests with token buckets keyed by client IP,
+// login identifier or anything else that identifies the caller.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+// Rate allows Requests requests per Period, refilled continuously. A zero
+// Requests disables the limit.
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRate parses rates such as "10/1m" or "100/1h".
+func ParseRate(s string) (Rate, error) {
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("rate %q must look like 10/1m", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("rate %q must start with a request count", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("rate %q must end with a positive duration", s)
+	}
+	return Rate{Requests: n, Period: d}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Requests, r.Period)
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket size and Remaining the tokens left in it
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token, zero when Allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter takes a token for key. Implementations backed by a shared store such
+// as Redis let several instances enforce one limit.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// WriteHeaders sets the RateLimit-* headers describing result, plus
+// Retry-After when the request was rejected. When several limits apply the
+// headers describe the one closest to running out.
+func WriteHeaders(w http.ResponseWriter, result Result) {
+	if previous, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining")); err == nil && result.Allowed && previous < result.Remaining {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+	}
+}
+
+// seconds rounds up so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ErrTooManyRequests is returned to clients that ran out of tokens.
+var ErrTooManyRequests = types.TooManyRequests("rate_limited", "too many requests, please try again later")
+
+// Check takes a token for key and writes the headers. When the request is
+// over the limit it also writes the 429 response and returns false. A limiter
+// that fails lets the request through rather than locking everyone out.
+func Check(w http.ResponseWriter, r *http.Request, limiter Limiter, key string) bool {
+	if limiter == nil {
+		return true
+	}
+	result, err := limiter.Allow(r.Context(), key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rate limiter failed, letting request through", "err", err)
+		return true
+	}
+	WriteHeaders(w, result)
+	if !result.Allowed {
+		slog.WarnContext(r.Context(), "rate limit exceeded", "key", key)
+		utils.WriteError(w, ErrTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// Middleware limits next per client IP, with keys prefixed by name so several
+// limits can share one Limiter.
+func Middleware(limiter Limiter, name string, trustProxy bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !Check(w, r, limiter, name+":ip:"+ClientIP(r, trustProxy)) {
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// ClientIP returns the address of the caller. Behind a proxy such as Render's
+// load balancer, trustProxy takes the last X-Forwarded-For entry, which is the
+// one the proxy appended and the client cannot forge.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rate
+		wantErr bool
+	}{
+		{in: "10/1m", want: Rate{Requests: 10, Period: time.Minute}},
+		{in: "0/1h", want: Rate{Requests: 0, Period: time.Hour}},
+		{in: "10", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "x/1m", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// newTestLimiter returns a limiter whose clock only moves when advance is called.
+func newTestLimiter(rate Rate) (*MemoryLimiter, func(time.Duration)) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	l := NewMemoryLimiter(rate)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMemoryLimiterBurstAndRefill(t *testing.T) {
+	l, advance := newTestLimiter(Rate{Requests: 3, Period: 3 * time.Minute})
+
+	for i := 0; i < 3; i++ {
+		result, _ := l.Allow(context.Background(), "k")
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+
+	result, _ := l.Allow(context.Background(), "k")
+	if result.Allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if result.RetryAfter != time.Minute {
+		t.Fatalf("RetryAfter = %v, want one token's worth, 1m", result.RetryAfter)
+	}
+
+	// one token comes back per minute, not the whole bucket
+	advance(time.Minute)
+	if result, _ := l.Allow(context.Background(), "k"); !result.Allowed {
+		t.Fatal("request after a refill was rejected")
+	}
+	if result, _ := l.Allow(context.Background(), "k"); result.Allowed {
+		t.Fatal("second request after refilling a single token was allowed")
+	}
+}
+
+func TestMemoryLimiterKeysAreIndependent(t *testing.T) {
+	l, _ := newTestLimiter(Rate{Requests: 1, Period: time.Minute})
+
+	if result, _ := l.Allow(context.Background(), "a"); !result.Allowed {
+		t.Fatal("first request for a was rejected")
+	}
+	if result, _ := l.Allow(context.Background(), "b"); !result.Allowed {
+		t.Fatal("a's requests used up b's bucket")
+	}
+	if result, _ := l.Allow(context.Background(), "a"); result.Allowed {
+		t.Fatal("second request for a was allowed")
+	}
+}
+
+func TestMemoryLimiterZeroRateDisables(t *testing.T) {
+	l, _ := newTestLimiter(Rate{Requests: 0, Period: time.Minute})
+	for i := 0; i < 100; i++ {
+		if result, _ := l.Allow(context.Background(), "k"); !result.Allowed {
+			t.Fatalf("request %d rejected by a disabled limit", i+1)
+		}
+	}
+}
+
+func TestMemoryLimiterSweepsFullBuckets(t *testing.T) {
+	l, advance := newTestLimiter(Rate{Requests: 1, Period: time.Minute})
+	l.Allow(context.Background(), "a")
+
+	advance(2 * time.Minute)
+	l.Allow(context.Background(), "b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("refilled bucket was not swept")
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+func TestCheck(t *testing.T) {
+	l, _ := newTestLimiter(Rate{Requests: 1, Period: time.Minute})
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+
+	w := httptest.NewRecorder()
+	if !Check(w, r, l, "k") {
+		t.Fatal("first request was rejected")
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("headers = %v, want the limit and remaining tokens", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	if Check(w, r, l, "k") {
+		t.Fatal("request over the limit was let through")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("rejected request got %d with Retry-After %q, want 429 and 60", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if !Check(httptest.NewRecorder(), r, nil, "k") {
+		t.Fatal("a nil limiter rejected the request")
+	}
+	// failing open keeps a broken limiter from locking everyone out
+	if !Check(httptest.NewRecorder(), r, failingLimiter{}, "k") {
+		t.Fatal("a failing limiter rejected the request")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+
+	if got := ClientIP(r, false); got != "10.0.0.1" {
+		t.Errorf("ClientIP without a trusted proxy = %q, want the peer address", got)
+	}
+	// the client controls every entry but the last, which the proxy appended
+	if got := ClientIP(r, true); got != "2.2.2.2" {
+		t.Errorf("ClientIP behind a trusted proxy = %q, want the last forwarded address", got)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	if got := ClientIP(r, true); got != "10.0.0.1" {
+		t.Errorf("ClientIP behind a proxy without the header = %q, want the peer address", got)
+	}
+}
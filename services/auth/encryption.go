@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Secrets encrypts secrets kept in the database, such as TOTP secrets. main
+// sets it from configs; it is nil when no encryption key is configured.
+var Secrets *SecretCipher
+
+// SecretCipher encrypts with AES-256-GCM under its current key and decrypts
+// with any of its keys, picked by the key ID stored with each ciphertext, so
+// the key can rotate without re-encrypting everything at once.
+type SecretCipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewSecretCipher takes base64 encoded 32 byte keys: key encrypts, previous
+// keys only decrypt.
+func NewSecretCipher(key string, previous []string) (*SecretCipher, error) {
+	c := &SecretCipher{keys: make(map[string]cipher.AEAD)}
+	for i, encoded := range append([]string{key}, previous...) {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("encryption keys must be 32 bytes encoded as base64")
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		// like signing key IDs, derived from the key without revealing it
+		sum := sha256.Sum256(append([]byte("kid|"), raw...))
+		id := hex.EncodeToString(sum[:4])
+		if i == 0 {
+			c.current = id
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// Encrypt seals plaintext for userID. Binding the ciphertext to the user keeps
+// it from being copied onto another user's row.
+func (c *SecretCipher) Encrypt(userID int, plaintext string) (string, error) {
+	aead := c.keys[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData(userID))
+	return c.current + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a ciphertext Encrypt sealed for userID.
+func (c *SecretCipher) Decrypt(userID int, ciphertext string) (string, error) {
+	id, encoded, ok := strings.Cut(ciphertext, ".")
+	if !ok {
+		return "", fmt.Errorf("malformed ciphertext")
+	}
+	aead, ok := c.keys[id]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %q", id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed ciphertext")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(userID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Len returns the number of keys secrets are decrypted with.
+func (c *SecretCipher) Len() int {
+	return len(c.keys)
+}
+
+func additionalData(userID int) []byte {
+	return []byte("user|" + strconv.Itoa(userID))
+}
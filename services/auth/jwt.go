@@ -2,36 +2,75 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/logging"
 	"github.com/Waris-Shaik/todo/types"
 	"github.com/Waris-Shaik/todo/utils"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 )
 
 type contextKey string
 
 const UserKey contextKey = "userID"
 
+// VerifiedEmailRoutes names the routes a user may only call once their email
+// is verified. main fills it from configs.
+var VerifiedEmailRoutes = map[string]bool{}
+
+var ErrEmailNotVerified = types.Forbidden("email_not_verified", "please verify your email address first")
+
+var errInvalidToken = errors.New("invalid token")
+
+// sessionPrecision is the precision access tokens are issued with and of the
+// cutoff a log-out-everywhere sets. It must be fine enough that a login right
+// after a revocation is not caught by it.
+const sessionPrecision = time.Millisecond
+
+func init() {
+	// RFC 7519 allows fractional NumericDates; whole seconds would lump a
+	// login in with the revocation made earlier in the same second. They are
+	// parsed as float64 and truncated, so keep more digits than
+	// sessionPrecision needs and round back to it.
+	jwt.TimePrecision = time.Microsecond
+}
+
 func CreateJWT(userID int) (string, error) {
-	secret := os.Getenv("JWT_SECRET_KEY")
-	if len(secret) == 0 {
-		return "", fmt.Errorf("JWT_SECRET_KEY is not set")
+	return createJWT(userID, time.Now())
+}
+
+// createJWT signs an access token for userID issued at now.
+func createJWT(userID int, now time.Time) (string, error) {
+	if Keys == nil {
+		return "", fmt.Errorf("no signing keys configured")
+	}
+	now = now.Truncate(sessionPrecision)
+
+	// unique token ID so a single token can be revoked
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
 	}
-	secretBytes := []byte(secret)
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    userID,
-		"expiredAt": time.Now().Add(15 * time.Minute).Unix(), // Use Unix timestamp
-	})
+	claims := jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   strconv.Itoa(userID),
+		Issuer:    Keys.issuer,
+		Audience:  jwt.ClaimStrings{Keys.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+	}
 
-	// Sign token
-	tokenString, err := token.SignedString(secretBytes)
+	tokenString, err := Keys.sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to create token: %w", err)
 	}
@@ -39,93 +78,186 @@ func CreateJWT(userID int) (string, error) {
 	return tokenString, nil
 }
 
-func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+// WithJWTAuth lets requests through that carry a valid session JWT or a
+// personal access token granted every scope in scopes. Routes without scopes
+// only accept session JWTs.
+func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore, revocations types.TokenRevocationStore, accessTokens types.PersonalAccessTokenStore, scopes ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get token from cookies
-		tokenString := getTokenFromCookie(r)
+		// Get token from the Authorization header or cookies
+		tokenString := TokenFromRequest(r)
 		if tokenString == "" {
-			log.Println("Unauthorized: Empty token")
-			permissionDenied(w)
+			slog.DebugContext(r.Context(), "auth: no token")
+			loginRequired(w, false)
 			return
 		}
 
-		// Validate token
-		token, err := validateJWT(tokenString)
-		if err != nil {
-			log.Println("Failed to validate JWT token:", err)
-			permissionDenied(w)
-			return
-		}
-
-		if !token.Valid {
-			log.Println("Invalid token")
-			permissionDenied(w)
-			return
+		var userID int
+		if IsPersonalAccessToken(tokenString) {
+			token, err := checkPersonalAccessToken(r.Context(), accessTokens, tokenString)
+			if err != nil {
+				slog.InfoContext(r.Context(), "auth: invalid personal access token", "err", err)
+				if db.IsUnavailable(err) {
+					utils.WriteError(w, err)
+					return
+				}
+				loginRequired(w, true)
+				return
+			}
+			if len(scopes) == 0 || len(missingScopes(token.Scopes, scopes)) > 0 {
+				slog.InfoContext(r.Context(), "auth: token lacks scope", "token_user_id", token.UserID, "token_id", token.ID, "required", scopes)
+				insufficientScope(w, scopes)
+				return
+			}
+			userID = token.UserID
+		} else {
+			var err error
+			userID, err = userIDFromJWT(r.Context(), revocations, tokenString)
+			if err != nil {
+				if db.IsUnavailable(err) {
+					utils.WriteError(w, err)
+					return
+				}
+				loginRequired(w, true)
+				return
+			}
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		userIDFloat, ok := claims["userID"].(float64)
-		if !ok {
-			log.Println("Failed to assert userID type")
-			permissionDenied(w)
+		user, err := store.GetUserByID(r.Context(), userID)
+		if err != nil {
+			slog.InfoContext(r.Context(), "auth: loading user failed", "token_user_id", userID, "err", err)
+			if db.IsUnavailable(err) {
+				utils.WriteError(w, err)
+				return
+			}
+			loginRequired(w, true)
 			return
 		}
-		userID := int(userIDFloat)
 
-		// Check token expiration
-		expiredAtFloat, ok := claims["expiredAt"].(float64)
-		if !ok {
-			log.Println("Failed to assert expiredAt type")
-			permissionDenied(w)
-			return
-		}
-		if time.Unix(int64(expiredAtFloat), 0).Before(time.Now()) {
-			log.Println("Token has expired")
-			permissionDenied(w)
+		if user.DeletedAt != nil {
+			slog.InfoContext(r.Context(), "auth: account scheduled for deletion", "token_user_id", user.ID)
+			loginRequired(w, true)
 			return
 		}
 
-		user, err := store.GetUserByID(userID)
-		if err != nil {
-			log.Println("Failed to get user by ID:", err)
-			permissionDenied(w)
+		if user.EmailVerifiedAt == nil && requiresVerifiedEmail(r) {
+			slog.InfoContext(r.Context(), "auth: email not verified", "token_user_id", user.ID)
+			utils.WriteError(w, ErrEmailNotVerified)
 			return
 		}
 
-		// Add user ID to context
+		// Add user ID to context and to the request's log lines
+		logging.SetUserID(r.Context(), user.ID)
 		ctx := context.WithValue(r.Context(), UserKey, user.ID)
 		handlerFunc(w, r.WithContext(ctx))
 	}
 }
 
+// userIDFromJWT validates a session JWT and returns the user it was issued to.
+func userIDFromJWT(ctx context.Context, revocations types.TokenRevocationStore, tokenString string) (int, error) {
+	// Validate the signature, expiry, issuer and audience
+	claims, err := validateJWT(tokenString)
+	if err != nil {
+		slog.InfoContext(ctx, "auth: invalid token", "err", err)
+		return 0, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		slog.InfoContext(ctx, "auth: token has no user subject")
+		return 0, errInvalidToken
+	}
+
+	// Check token revocation
+	if claims.ID == "" {
+		slog.InfoContext(ctx, "auth: token has no jti claim")
+		return 0, errInvalidToken
+	}
+	if claims.IssuedAt == nil {
+		slog.InfoContext(ctx, "auth: token has no iat claim")
+		return 0, errInvalidToken
+	}
+	revoked, err := isRevoked(ctx, revocations, claims.ID, userID, claims.IssuedAt.Time)
+	if err != nil {
+		slog.ErrorContext(ctx, "auth: checking token revocation failed", "err", err)
+		return 0, err
+	}
+	if revoked {
+		slog.InfoContext(ctx, "auth: token revoked", "token_user_id", userID)
+		return 0, errInvalidToken
+	}
+	return userID, nil
+}
+
+func requiresVerifiedEmail(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	return VerifiedEmailRoutes[route.GetName()]
+}
+
+// TokenFromRequest returns the access token sent as "Authorization: Bearer
+// <jwt>" or, without that header, in the token cookie. Clients that cannot
+// keep cookies use the header.
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	return getTokenFromCookie(r)
+}
+
 func getTokenFromCookie(r *http.Request) string {
 	cookie, err := r.Cookie("token")
 	if err != nil {
-		log.Println("Error in getTokenFromCookie:", err)
 		return ""
 	}
 	return cookie.Value
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("please login"))
+// loginRequired answers 401 with a Bearer challenge, flagged as RFC 6750's
+// invalid_token when a token was sent but not accepted.
+func loginRequired(w http.ResponseWriter, tokenSent bool) {
+	challenge := `Bearer realm="todo"`
+	if tokenSent {
+		challenge += `, error="invalid_token"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	utils.WriteError(w, types.Unauthorized("login_required", "please login"))
+}
+
+// insufficientScope answers 403 with RFC 6750's insufficient_scope challenge
+// naming the scopes the route needs, if a personal access token can have them.
+func insufficientScope(w http.ResponseWriter, scopes []string) {
+	challenge := `Bearer realm="todo", error="insufficient_scope"`
+	if len(scopes) == 0 {
+		w.Header().Set("WWW-Authenticate", challenge)
+		utils.WriteError(w, types.Forbidden("insufficient_scope", "personal access tokens cannot be used here, please login"))
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("%s, scope=%q", challenge, strings.Join(scopes, " ")))
+	utils.WriteError(w, types.Forbidden("insufficient_scope", fmt.Sprintf("token needs the %s scope", strings.Join(scopes, ", "))))
 }
 
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the signing method is HMAC
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		// Return secret key
-		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-	})
+// validateJWT checks tokenString against Keys and returns its claims.
+func validateJWT(tokenString string) (*jwt.RegisteredClaims, error) {
+	if Keys == nil {
+		return nil, fmt.Errorf("no signing keys configured")
+	}
+	claims := new(jwt.RegisteredClaims)
+	if _, err := Keys.parse(tokenString, claims, Keys.audience); err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
 
 func GetUserIDFromContext(ctx context.Context) int {
 	userID, ok := ctx.Value(UserKey).(int)
 	if !ok {
-		log.Println("Failed to get userID from context or userID is not int")
+		slog.ErrorContext(ctx, "no user ID in request context")
 		return -1
 	}
 	return userID
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/Waris-Shaik/todo/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keys signs and verifies access tokens. main sets it from configs.
+var Keys *KeySet
+
+// KeyConfig describes the keys access tokens are signed and verified with.
+type KeyConfig struct {
+	// Algorithm is HS256, RS256 or EdDSA
+	Algorithm string
+	// Secret signs HS256 tokens. With another algorithm it only verifies, so
+	// tokens issued before switching stay valid until they expire.
+	Secret string
+	// PreviousSecrets verify tokens signed before the secret was rotated
+	PreviousSecrets []string
+	// PrivateKeyFile is a PEM encoded RSA or Ed25519 key for RS256 and EdDSA
+	PrivateKeyFile string
+	// PreviousPublicKeyFiles verify tokens signed with earlier private keys
+	PreviousPublicKeyFiles []string
+	// Issuer and Audience are set on every token and required when verifying
+	Issuer   string
+	Audience string
+}
+
+// signingKey is one key in a KeySet; sign is nil for keys that only verify.
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+// KeySet signs tokens with its current key and verifies them with any of its
+// keys, picked by the token's kid header. Rotating adds a new current key and
+// keeps the old one for verification until the tokens it signed expire, so no
+// one is logged out.
+type KeySet struct {
+	current signingKey
+	keys    map[string]signingKey
+	// secrets are the HMAC secrets, current first, for signatures other
+	// than access tokens such as email verification links
+	secrets  [][]byte
+	methods  []string
+	issuer   string
+	audience string
+}
+
+// NewKeySet loads the keys config describes.
+func NewKeySet(config KeyConfig) (*KeySet, error) {
+	if config.Secret == "" {
+		return nil, fmt.Errorf("no secret configured")
+	}
+	set := &KeySet{keys: make(map[string]signingKey), issuer: config.Issuer, audience: config.Audience}
+	set.secrets = append(set.secrets, []byte(config.Secret))
+
+	secret := hmacKey(config.Secret)
+	switch config.Algorithm {
+	case "", "HS256":
+		set.current = secret
+	case "RS256", "EdDSA":
+		current, err := loadPrivateKey(config.PrivateKeyFile, config.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		set.current = current
+		secret.sign = nil
+	default:
+		return nil, fmt.Errorf("algorithm must be HS256, RS256 or EdDSA, got %q", config.Algorithm)
+	}
+
+	set.add(set.current)
+	set.add(secret)
+	for _, previous := range config.PreviousSecrets {
+		key := hmacKey(previous)
+		key.sign = nil
+		set.add(key)
+		set.secrets = append(set.secrets, []byte(previous))
+	}
+	for _, path := range config.PreviousPublicKeyFiles {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		set.add(key)
+	}
+	return set, nil
+}
+
+// add registers key for verification unless a key with its ID is already known.
+func (s *KeySet) add(key signingKey) {
+	if _, ok := s.keys[key.id]; ok {
+		return
+	}
+	s.keys[key.id] = key
+
+	for _, method := range s.methods {
+		if method == key.method.Alg() {
+			return
+		}
+	}
+	s.methods = append(s.methods, key.method.Alg())
+}
+
+// Len returns the number of keys tokens are verified with.
+func (s *KeySet) Len() int {
+	return len(s.keys)
+}
+
+// CurrentKeyID returns the kid new tokens are signed with.
+func (s *KeySet) CurrentKeyID() string {
+	return s.current.id
+}
+
+// sign signs claims with the current key.
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.current.method, claims)
+	token.Header["kid"] = s.current.id
+	return token.SignedString(s.current.sign)
+}
+
+// parse verifies tokenString with the key its kid names and checks the
+// registered claims, requiring audience.
+func (s *KeySet) parse(tokenString string, claims jwt.Claims, audience string) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		// a token must not pick an algorithm other than its key's
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verify, nil
+	},
+		jwt.WithValidMethods(s.methods),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+}
+
+func hmacKey(secret string) signingKey {
+	// the ID is derived from the secret so it stays stable across restarts
+	// without revealing it
+	sum := sha256.Sum256([]byte("kid|" + secret))
+	return signingKey{
+		id:     "hs-" + hex.EncodeToString(sum[:6]),
+		method: jwt.SigningMethodHS256,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}
+}
+
+func loadPrivateKey(path, algorithm string) (signingKey, error) {
+	if path == "" {
+		return signingKey{}, fmt.Errorf("%s needs a private key file", algorithm)
+	}
+	block, err := readPEM(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	var private any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != "RS256" {
+			return signingKey{}, fmt.Errorf("%s holds an RSA key, which needs RS256", path)
+		}
+		key, err := publicKey(&private.PublicKey)
+		key.sign = private
+		return key, err
+	case ed25519.PrivateKey:
+		if algorithm != "EdDSA" {
+			return signingKey{}, fmt.Errorf("%s holds an Ed25519 key, which needs EdDSA", path)
+		}
+		key, err := publicKey(private.Public())
+		key.sign = private
+		return key, err
+	default:
+		return signingKey{}, fmt.Errorf("%s holds a %T, want an RSA or Ed25519 key", path, private)
+	}
+}
+
+func loadPublicKey(path string) (signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		return signingKey{}, fmt.Errorf("reading %s: unsupported PEM block %q", path, block.Type)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	key, err := publicKey(public)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// publicKey returns the verification half of an RSA or Ed25519 key, with an ID
+// taken from its hash.
+func publicKey(public any) (signingKey, error) {
+	key := signingKey{verify: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return signingKey{}, fmt.Errorf("unsupported public key %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sum := sha256.Sum256(der)
+	key.id = base64.RawURLEncoding.EncodeToString(sum[:12])
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("reading %s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// jwk is a public key in JSON Web Key form.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// jwks returns the public keys in the set, ordered by ID. HS256 secrets are
+// never published, so a set of only those has none.
+func (s *KeySet) jwks() []jwk {
+	keys := make([]jwk, 0, len(s.keys))
+	for _, key := range s.keys {
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, jwk{
+				KeyType:   "RSA",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, jwk{
+				KeyType:   "OKP",
+				KeyID:     key.id,
+				Use:       "sig",
+				Algorithm: key.method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
+
+// HandleJWKS serves the public keys of Keys as a JSON Web Key Set, so other
+// services can verify our access tokens.
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := make([]jwk, 0)
+	if Keys != nil {
+		keys = Keys.jwks()
+	}
+
+	response := struct {
+		Keys []jwk `json:"keys"`
+	}{
+		Keys: keys,
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, response)
+}
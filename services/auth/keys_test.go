@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "http://test"
+	testAudience = "todo-api"
+)
+
+// useKeys sets Keys for the rest of t.
+func useKeys(t *testing.T, config KeyConfig) *KeySet {
+	t.Helper()
+	if config.Issuer == "" {
+		config.Issuer, config.Audience = testIssuer, testAudience
+	}
+	keys, err := NewKeySet(config)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	previous := Keys
+	Keys = keys
+	t.Cleanup(func() { Keys = previous })
+	return keys
+}
+
+// writePEM writes block to a file in a temporary directory and returns its path.
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeRSAKey(t *testing.T) (privatePath, publicPath string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), writePEM(t, "rsa.pub", "PUBLIC KEY", public)
+}
+
+func writeEd25519Key(t *testing.T) (privatePath, publicPath string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "ed25519.pem", "PRIVATE KEY", privateDER), writePEM(t, "ed25519.pub", "PUBLIC KEY", publicDER)
+}
+
+func TestSecretRotation(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "old"})
+	token, err := CreateJWT(1)
+	if err != nil {
+		t.Fatalf("CreateJWT: %v", err)
+	}
+
+	useKeys(t, KeyConfig{Secret: "new", PreviousSecrets: []string{"old"}})
+	if _, err := validateJWT(token); err != nil {
+		t.Fatalf("token signed with a previous secret = %v, want valid", err)
+	}
+
+	// once the old secret is dropped its tokens stop working
+	useKeys(t, KeyConfig{Secret: "new"})
+	if _, err := validateJWT(token); err == nil {
+		t.Fatal("token signed with a dropped secret is still valid")
+	}
+}
+
+func TestValidateJWTChecksRegisteredClaims(t *testing.T) {
+	keys := useKeys(t, KeyConfig{Secret: "secret"})
+	now := time.Now()
+	valid := jwt.RegisteredClaims{
+		ID:        "jti",
+		Subject:   "1",
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*jwt.RegisteredClaims)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*jwt.RegisteredClaims) {}, valid: true},
+		{name: "expired", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }},
+		{name: "no expiry", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }},
+		{name: "other issuer", modify: func(c *jwt.RegisteredClaims) { c.Issuer = "http://evil" }},
+		{name: "other audience", modify: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other-api"} }},
+		{name: "issued in the future", modify: func(c *jwt.RegisteredClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) }},
+		{name: "not yet valid", modify: func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) }},
+	}
+	for _, tt := range tests {
+		claims := valid
+		tt.modify(&claims)
+		token, err := keys.sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := validateJWT(token); (err == nil) != tt.valid {
+			t.Errorf("%s: validateJWT = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateJWTRejectsUnknownKeysAndAlgorithms(t *testing.T) {
+	privatePath, _ := writeRSAKey(t)
+	keys := useKeys(t, KeyConfig{Algorithm: "RS256", Secret: "secret", PrivateKeyFile: privatePath})
+	claims := jwt.RegisteredClaims{
+		Subject:   "1",
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	unknown.Header["kid"] = "unknown"
+	token, _ := unknown.SignedString([]byte("secret"))
+	if _, err := validateJWT(token); err == nil {
+		t.Error("token with an unknown kid is valid")
+	}
+
+	// an HS256 token naming the RSA key must not be checked with the public
+	// key as the HMAC secret
+	der, err := x509.MarshalPKIXPublicKey(keys.current.verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = keys.CurrentKeyID()
+	token, _ = confused.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if _, err := validateJWT(token); err == nil {
+		t.Error("HS256 token signed with the RSA public key is valid")
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			writeKey := writeRSAKey
+			if algorithm == "EdDSA" {
+				writeKey = writeEd25519Key
+			}
+			oldPrivate, oldPublic := writeKey(t)
+			newPrivate, _ := writeKey(t)
+
+			useKeys(t, KeyConfig{Algorithm: algorithm, Secret: "secret", PrivateKeyFile: oldPrivate})
+			oldToken, err := CreateJWT(1)
+			if err != nil {
+				t.Fatalf("CreateJWT: %v", err)
+			}
+			if _, err := validateJWT(oldToken); err != nil {
+				t.Fatalf("validateJWT = %v", err)
+			}
+
+			// tokens signed with the old key survive the rotation through its public key
+			keys := useKeys(t, KeyConfig{Algorithm: algorithm, Secret: "secret", PrivateKeyFile: newPrivate, PreviousPublicKeyFiles: []string{oldPublic}})
+			if _, err := validateJWT(oldToken); err != nil {
+				t.Fatalf("token signed with the previous key = %v, want valid", err)
+			}
+
+			// the secret only verifies once another algorithm signs
+			hsKeys, err := NewKeySet(KeyConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hsToken, err := hsKeys.sign(jwt.RegisteredClaims{
+				Subject:   "1",
+				Issuer:    testIssuer,
+				Audience:  jwt.ClaimStrings{testAudience},
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := validateJWT(hsToken); err != nil {
+				t.Fatalf("HS256 token issued before switching = %v, want valid", err)
+			}
+
+			jwks := keys.jwks()
+			if len(jwks) != 2 {
+				t.Fatalf("jwks has %d keys, want the current and previous public keys", len(jwks))
+			}
+			for _, key := range jwks {
+				if key.Algorithm != algorithm || key.Use != "sig" || key.KeyID == "" {
+					t.Errorf("jwk %+v, want a %s signing key with an ID", key, algorithm)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyConfigErrors(t *testing.T) {
+	rsaPrivate, rsaPublic := writeRSAKey(t)
+	edPrivate, _ := writeEd25519Key(t)
+
+	tests := []struct {
+		name   string
+		config KeyConfig
+	}{
+		{name: "no secret", config: KeyConfig{}},
+		{name: "unknown algorithm", config: KeyConfig{Algorithm: "none", Secret: "secret"}},
+		{name: "no private key", config: KeyConfig{Algorithm: "RS256", Secret: "secret"}},
+		{name: "RSA key for EdDSA", config: KeyConfig{Algorithm: "EdDSA", Secret: "secret", PrivateKeyFile: rsaPrivate}},
+		{name: "Ed25519 key for RS256", config: KeyConfig{Algorithm: "RS256", Secret: "secret", PrivateKeyFile: edPrivate}},
+		{name: "public key as private key", config: KeyConfig{Algorithm: "RS256", Secret: "secret", PrivateKeyFile: rsaPublic}},
+		{name: "missing public key", config: KeyConfig{Secret: "secret", PreviousPublicKeyFiles: []string{filepath.Join(t.TempDir(), "missing.pub")}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeySet(tt.config); err == nil {
+			t.Errorf("%s: NewKeySet succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestHandleJWKS(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "secret"})
+
+	w := httptest.NewRecorder()
+	HandleJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	var response struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	// secrets are never published
+	if w.Code != http.StatusOK || response.Keys == nil || len(response.Keys) != 0 {
+		t.Fatalf("JWKS of an HS256 set answered %d with %s, want an empty key list", w.Code, w.Body.String())
+	}
+}
+
+func TestIssuedAtKeepsMilliseconds(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "secret"})
+	start := time.Now().Add(-2 * time.Second).Truncate(time.Millisecond)
+	// iat is parsed through a float64, which must not move it to the
+	// millisecond before, or it would fall behind a cutoff it is level with
+	for i := 0; i < 1000; i++ {
+		issuedAt := start.Add(time.Duration(i) * time.Millisecond)
+		token, err := createJWT(1, issuedAt.Add(999*time.Microsecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := validateJWT(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := claims.IssuedAt.Round(sessionPrecision); !got.Equal(issuedAt) {
+			t.Fatalf("iat = %v, want %v", got, issuedAt)
+		}
+	}
+}
+
+func TestRotateSessions(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "secret"})
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	before, err := createJWT(1, time.Now().Add(-2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stolen session made within the same second as the caller's must not
+	// survive the password change either
+	stolen, err := createJWT(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := createJWT(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := RotateSessions(ctx, store, store, 1, current)
+	if err != nil {
+		t.Fatalf("RotateSessions: %v", err)
+	}
+	// the new token must be usable at once, not issued in the future
+	if userID, err := userIDFromJWT(ctx, store, token); err != nil || userID != 1 {
+		t.Fatalf("rotated token = %d, %v; want valid for user 1", userID, err)
+	}
+	for name, old := range map[string]string{"earlier": before, "same second": stolen, "caller's": current} {
+		if _, err := userIDFromJWT(ctx, store, old); err == nil {
+			t.Errorf("%s token is still valid after the rotation", name)
+		}
+	}
+	claims, err := validateJWT(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := store.IsTokenRevoked(ctx, claims.ID); err != nil || !revoked {
+		t.Errorf("caller's token revoked by jti = %v, %v; want true", revoked, err)
+	}
+
+	// logging out everywhere also ends the rotated token, issued in the same second
+	if err := RevokeAllSessions(ctx, store, store, 1); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	if _, err := userIDFromJWT(ctx, store, token); err == nil {
+		t.Fatal("token is still valid after logging out everywhere")
+	}
+}
+
+func TestEmailVerificationTokenRotation(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "old"})
+	token, err := CreateEmailVerificationToken(7, "Jane@example.com")
+	if err != nil {
+		t.Fatalf("CreateEmailVerificationToken: %v", err)
+	}
+	if userID, err := ParseEmailVerificationToken(token); err != nil || userID != 7 {
+		t.Fatalf("ParseEmailVerificationToken = %d, %v; want 7", userID, err)
+	}
+	if !CheckEmailVerificationToken(token, "jane@example.com") {
+		t.Fatal("token does not check out for the email it was issued for, in another case")
+	}
+	if CheckEmailVerificationToken(token, "john@example.com") {
+		t.Fatal("token checks out for another email")
+	}
+
+	useKeys(t, KeyConfig{Secret: "new", PreviousSecrets: []string{"old"}})
+	if !CheckEmailVerificationToken(token, "jane@example.com") {
+		t.Fatal("link signed with a previous secret is rejected")
+	}
+	useKeys(t, KeyConfig{Secret: "new"})
+	if CheckEmailVerificationToken(token, "jane@example.com") {
+		t.Fatal("link signed with a dropped secret is accepted")
+	}
+
+	expired := strconv.Itoa(7) + "." + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + ".sig"
+	if _, err := ParseEmailVerificationToken(expired); err == nil {
+		t.Fatal("expired token parsed")
+	}
+}
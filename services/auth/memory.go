@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// MemoryStore keeps refresh tokens and revocations in memory, for local runs
+// and tests without a database server. It mirrors the behaviour of Store.
+type MemoryStore struct {
+	mu            sync.Mutex
+	nextID        int
+	refreshTokens map[int]types.RefreshToken
+	revoked       map[string]time.Time
+	cutoffs       map[int]time.Time
+	resetTokens   []types.PasswordResetToken
+	accessTokens  []types.PersonalAccessToken
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:        1,
+		refreshTokens: make(map[int]types.RefreshToken),
+		revoked:       make(map[string]time.Time),
+		cutoffs:       make(map[int]time.Time),
+	}
+}
+
+func (s *MemoryStore) CreateRefreshToken(_ context.Context, token types.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertRefreshToken(token)
+	return nil
+}
+
+func (s *MemoryStore) GetRefreshTokenByHash(_ context.Context, hash string) (*types.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, types.NotFound("refresh_token_not_found", "refresh token not found")
+}
+
+func (s *MemoryStore) RotateRefreshToken(_ context.Context, usedID int, next types.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.refreshTokens[usedID]
+	if !ok || current.UsedAt != nil || current.RevokedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	now := time.Now().UTC()
+	current.UsedAt = &now
+	s.refreshTokens[usedID] = current
+
+	s.insertRefreshToken(next)
+	return nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(token types.RefreshToken) bool { return token.FamilyID == familyID })
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(token types.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (s *MemoryStore) RevokeToken(_ context.Context, jti string, userID int, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.revoked {
+		if exp.Before(now) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *MemoryStore) RevokeUserTokens(_ context.Context, userID int, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cutoffs[userID] = before
+	return nil
+}
+
+func (s *MemoryStore) GetUserTokenCutoff(_ context.Context, userID int) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff, ok := s.cutoffs[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &cutoff, nil
+}
+
+// insertRefreshToken stores token under a new ID. Callers must hold s.mu.
+func (s *MemoryStore) CreatePasswordResetToken(_ context.Context, token types.PasswordResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = len(s.resetTokens) + 1
+	token.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	s.resetTokens = append(s.resetTokens, token)
+	return nil
+}
+
+func (s *MemoryStore) GetPasswordResetTokenByHash(_ context.Context, hash string) (*types.PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.resetTokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrPasswordResetTokenInvalid
+}
+
+func (s *MemoryStore) ConsumePasswordResetToken(_ context.Context, hash string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID := 0
+	for _, token := range s.resetTokens {
+		if token.TokenHash == hash && token.UsedAt == nil && token.ExpiresAt.After(now) {
+			userID = token.UserID
+		}
+	}
+	if userID == 0 {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	usedAt := now.UTC().Truncate(time.Second)
+	for i, token := range s.resetTokens {
+		if token.UserID == userID && token.UsedAt == nil {
+			s.resetTokens[i].UsedAt = &usedAt
+		}
+	}
+	return userID, nil
+}
+
+func (s *MemoryStore) CreatePersonalAccessToken(_ context.Context, token types.PersonalAccessToken) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = len(s.accessTokens) + 1
+	token.Scopes = append([]string(nil), token.Scopes...)
+	token.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	s.accessTokens = append(s.accessTokens, token)
+	return token.ID, nil
+}
+
+func (s *MemoryStore) GetPersonalAccessTokens(_ context.Context, userID int) ([]*types.PersonalAccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]*types.PersonalAccessToken, 0)
+	for i := len(s.accessTokens) - 1; i >= 0; i-- {
+		token := s.accessTokens[i]
+		if token.UserID == userID && token.RevokedAt == nil {
+			tokens = append(tokens, &token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) GetPersonalAccessTokenByHash(_ context.Context, hash string) (*types.PersonalAccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.accessTokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrPersonalAccessTokenNotFound
+}
+
+func (s *MemoryStore) TouchPersonalAccessToken(_ context.Context, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id >= 1 && id <= len(s.accessTokens) {
+		usedAt := at.UTC().Truncate(time.Second)
+		s.accessTokens[id-1].LastUsedAt = &usedAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokePersonalAccessToken(_ context.Context, userID int, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > len(s.accessTokens) {
+		return ErrPersonalAccessTokenNotFound
+	}
+	token := &s.accessTokens[id-1]
+	if token.UserID != userID || token.RevokedAt != nil {
+		return ErrPersonalAccessTokenNotFound
+	}
+	revokedAt := at.UTC().Truncate(time.Second)
+	token.RevokedAt = &revokedAt
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserPersonalAccessTokens(_ context.Context, userID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revokedAt := at.UTC().Truncate(time.Second)
+	for i := range s.accessTokens {
+		if token := &s.accessTokens[i]; token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) insertRefreshToken(token types.RefreshToken) {
+	token.ID = s.nextID
+	token.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	s.refreshTokens[token.ID] = token
+	s.nextID++
+}
+
+// revokeRefreshTokens revokes every active token matching match. Callers must hold s.mu.
+func (s *MemoryStore) revokeRefreshTokens(match func(types.RefreshToken) bool) {
+	now := time.Now().UTC()
+	for id, token := range s.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			s.refreshTokens[id] = token
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// personalTokenServer runs WithJWTAuth for one user on memory stores.
+type personalTokenServer struct {
+	t      *testing.T
+	userID int
+	users  *user.MemoryStore
+	tokens *auth.MemoryStore
+}
+
+func newPersonalTokenServer(t *testing.T) *personalTokenServer {
+	t.Helper()
+	keys, err := auth.NewKeySet(auth.KeyConfig{Secret: "test-secret", Issuer: "http://test", Audience: "todo-api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := auth.Keys
+	auth.Keys = keys
+	t.Cleanup(func() { auth.Keys = previous })
+
+	users := user.NewMemoryStore()
+	userID, err := users.CreateUser(context.Background(), types.User{FirstName: "Jane", LastName: "Doe", UserName: "jane", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &personalTokenServer{t: t, userID: userID, users: users, tokens: auth.NewMemoryStore()}
+}
+
+// issue creates a personal access token for the user and returns the raw token.
+func (s *personalTokenServer) issue(scopes []string, expiresAt *time.Time) (string, *types.PersonalAccessToken) {
+	s.t.Helper()
+	raw, token, err := auth.IssuePersonalAccessToken(context.Background(), s.tokens, s.userID, "script", scopes, expiresAt)
+	if err != nil {
+		s.t.Fatalf("IssuePersonalAccessToken: %v", err)
+	}
+	return raw, token
+}
+
+// do sends token to a route needing scopes.
+func (s *personalTokenServer) do(token string, scopes ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	handler := auth.WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if userID := auth.GetUserIDFromContext(r.Context()); userID != s.userID {
+			s.t.Errorf("handler ran for user %d, want %d", userID, s.userID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, s.users, s.tokens, s.tokens, scopes...)
+
+	r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	s := newPersonalTokenServer(t)
+	readOnly, _ := s.issue([]string{auth.ScopeTodosRead}, nil)
+	readWrite, _ := s.issue([]string{auth.ScopeTodosRead, auth.ScopeTodosWrite}, nil)
+
+	tests := []struct {
+		name   string
+		token  string
+		scopes []string
+		want   int
+	}{
+		{name: "granted scope", token: readOnly, scopes: []string{auth.ScopeTodosRead}, want: http.StatusNoContent},
+		{name: "every scope granted", token: readWrite, scopes: []string{auth.ScopeTodosRead, auth.ScopeTodosWrite}, want: http.StatusNoContent},
+		{name: "missing scope", token: readOnly, scopes: []string{auth.ScopeTodosWrite}, want: http.StatusForbidden},
+		{name: "one of two scopes", token: readOnly, scopes: []string{auth.ScopeTodosRead, auth.ScopeTodosWrite}, want: http.StatusForbidden},
+		{name: "other scope", token: readWrite, scopes: []string{auth.ScopeProfileRead}, want: http.StatusForbidden},
+		// routes without scopes, such as minting more tokens, take sessions only
+		{name: "session only route", token: readWrite, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := s.do(tt.token, tt.scopes...); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	w := s.do(readOnly, auth.ScopeTodosWrite)
+	challenge := w.Header().Get("WWW-Authenticate")
+	if !strings.Contains(challenge, `error="insufficient_scope"`) || !strings.Contains(challenge, `scope="todos:write"`) {
+		t.Errorf("WWW-Authenticate = %q, want an insufficient_scope challenge naming todos:write", challenge)
+	}
+}
+
+func TestSessionTokensHaveEveryScope(t *testing.T) {
+	s := newPersonalTokenServer(t)
+	token, err := auth.CreateJWT(s.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scopes := range [][]string{nil, {auth.ScopeTodosWrite, auth.ScopeProfileRead}} {
+		if w := s.do(token, scopes...); w.Code != http.StatusNoContent {
+			t.Errorf("session token on a route needing %v = %d, want %d", scopes, w.Code, http.StatusNoContent)
+		}
+	}
+}
+
+func TestUnusablePersonalAccessTokens(t *testing.T) {
+	s := newPersonalTokenServer(t)
+	ctx := context.Background()
+	scopes := []string{auth.ScopeTodosRead}
+
+	revoked, token := s.issue(scopes, nil)
+	if err := s.tokens.RevokePersonalAccessToken(ctx, s.userID, token.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	expired, _ := s.issue(scopes, &past)
+	revokedAll, _ := s.issue(scopes, nil)
+	if err := s.tokens.RevokeUserPersonalAccessTokens(ctx, s.userID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "revoked", token: revoked},
+		{name: "expired", token: expired},
+		{name: "revoked with every other token", token: revokedAll},
+		{name: "unknown", token: auth.PersonalAccessTokenPrefix + "unknown"},
+	}
+	for _, tt := range tests {
+		w := s.do(tt.token, scopes...)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+			t.Errorf("%s token answered %d with %q, want 401 and invalid_token", tt.name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestPersonalAccessTokenLastUsed(t *testing.T) {
+	s := newPersonalTokenServer(t)
+	raw, token := s.issue([]string{auth.ScopeTodosRead}, nil)
+	if token.LastUsedAt != nil {
+		t.Fatal("new token has a last use")
+	}
+	if w := s.do(raw, auth.ScopeTodosRead); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	tokens, err := s.tokens.GetPersonalAccessTokens(context.Background(), s.userID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("GetPersonalAccessTokens = %v, %v; want one token", tokens, err)
+	}
+	if tokens[0].LastUsedAt == nil {
+		t.Error("using the token did not record its last use")
+	}
+	// only the hash is kept
+	if tokens[0].TokenHash == raw || tokens[0].TokenHash != auth.HashToken(raw) {
+		t.Errorf("stored hash = %q, want the hash of the raw token", tokens[0].TokenHash)
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrRefreshTokenInvalid = types.Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+	ErrRefreshTokenReused  = types.Unauthorized("refresh_token_reused", "refresh token has already been used")
+)
+
+// IssueRefreshToken starts a new token family for userID and returns the raw token.
+func IssueRefreshToken(ctx context.Context, store types.RefreshTokenStore, userID int) (string, error) {
+	familyID, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	raw, tokenHash, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = store.CreateRefreshToken(ctx, types.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// RotateRefreshToken exchanges a raw refresh token for a new one in the same
+// family. Presenting a token that was already used revokes the whole family,
+// since it means the token was copied by someone else.
+func RotateRefreshToken(ctx context.Context, store types.RefreshTokenStore, raw string) (int, string, error) {
+	current, err := store.GetRefreshTokenByHash(ctx, HashToken(raw))
+	if errors.Is(err, types.ErrNotFound) {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+
+	if current.UsedAt != nil {
+		slog.WarnContext(ctx, "refresh token reuse detected, revoking family", "token_user_id", current.UserID, "family_id", current.FamilyID)
+		if err := store.RevokeRefreshTokenFamily(ctx, current.FamilyID); err != nil {
+			return 0, "", err
+		}
+		return 0, "", ErrRefreshTokenReused
+	}
+
+	next, tokenHash, err := NewOpaqueToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = store.RotateRefreshToken(ctx, current.ID, types.RefreshToken{
+		UserID:    current.UserID,
+		FamilyID:  current.FamilyID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		slog.WarnContext(ctx, "refresh token reuse detected, revoking family", "token_user_id", current.UserID, "family_id", current.FamilyID)
+		if err := store.RevokeRefreshTokenFamily(ctx, current.FamilyID); err != nil {
+			return 0, "", err
+		}
+		return 0, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return current.UserID, next, nil
+}
+
+// RevokeRefreshToken revokes the family the raw refresh token belongs to.
+func RevokeRefreshToken(ctx context.Context, store types.RefreshTokenStore, raw string) error {
+	current, err := store.GetRefreshTokenByHash(ctx, HashToken(raw))
+	if err != nil {
+		return ErrRefreshTokenInvalid
+	}
+	return store.RevokeRefreshTokenFamily(ctx, current.FamilyID)
+}
+
+// HashToken returns the hex SHA-256 digest under which opaque tokens are stored.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewOpaqueToken returns a random token to hand out and the hash to store.
+func NewOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+	return raw, HashToken(raw), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// PasswordResetTTL is how long a password reset link stays valid.
+const PasswordResetTTL = time.Hour
+
+var ErrPasswordResetTokenInvalid = types.Validation("invalid_reset_token", "reset link is invalid or has expired")
+
+// IssuePasswordResetToken stores a new reset token for userID and returns the
+// raw token to email.
+func IssuePasswordResetToken(ctx context.Context, store types.PasswordResetStore, userID int) (string, error) {
+	raw, tokenHash, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = store.CreatePasswordResetToken(ctx, types.PasswordResetToken{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(PasswordResetTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// CheckPasswordResetToken returns the user a raw reset token was issued to if
+// it can still be used, without spending it.
+func CheckPasswordResetToken(ctx context.Context, store types.PasswordResetStore, raw string) (int, error) {
+	if raw == "" {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	token, err := store.GetPasswordResetTokenByHash(ctx, HashToken(raw))
+	if err != nil {
+		return 0, err
+	}
+	if token.UsedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	return token.UserID, nil
+}
+
+// ConsumePasswordResetToken spends a raw reset token and returns the user it
+// was issued to. Every other reset link of the user stops working as well.
+func ConsumePasswordResetToken(ctx context.Context, store types.PasswordResetStore, raw string) (int, error) {
+	if raw == "" {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	return store.ConsumePasswordResetToken(ctx, HashToken(raw), time.Now())
+}
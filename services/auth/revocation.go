@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// maxCachedLookups bounds the negative-lookup cache before expired entries are swept.
+const maxCachedLookups = 10000
+
+// RevocationCache wraps a TokenRevocationStore with an in-memory cache so that
+// WithJWTAuth does not hit the database on every request. Revocations are
+// cached until the token expires; "not revoked" answers are only trusted for
+// ttl so revocations made by other instances are picked up quickly.
+type RevocationCache struct {
+	store types.TokenRevocationStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	checked map[string]time.Time
+	cutoffs map[int]cutoffEntry
+}
+
+type cutoffEntry struct {
+	cutoff *time.Time
+	until  time.Time
+}
+
+func NewRevocationCache(store types.TokenRevocationStore, ttl time.Duration) *RevocationCache {
+	return &RevocationCache{
+		store:   store,
+		ttl:     ttl,
+		revoked: make(map[string]time.Time),
+		checked: make(map[string]time.Time),
+		cutoffs: make(map[int]cutoffEntry),
+	}
+}
+
+func (c *RevocationCache) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	if err := c.store.RevokeToken(ctx, jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revoked[jti] = expiresAt
+	delete(c.checked, jti)
+	return nil
+}
+
+func (c *RevocationCache) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if _, ok := c.revoked[jti]; ok {
+		c.mu.Unlock()
+		return true, nil
+	}
+	if until, ok := c.checked[jti]; ok && now.Before(until) {
+		c.mu.Unlock()
+		return false, nil
+	}
+	c.mu.Unlock()
+
+	revoked, err := c.store.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.checked)+len(c.revoked) > maxCachedLookups {
+		c.sweep(now)
+	}
+	if revoked {
+		// the expiry is unknown here, so keep it for the longest an access token lives
+		c.revoked[jti] = now.Add(AccessTokenTTL)
+	} else {
+		c.checked[jti] = now.Add(c.ttl)
+	}
+	return revoked, nil
+}
+
+func (c *RevocationCache) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	if err := c.store.RevokeUserTokens(ctx, userID, before); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cutoffs[userID] = cutoffEntry{cutoff: &before, until: time.Now().Add(c.ttl)}
+	return nil
+}
+
+func (c *RevocationCache) GetUserTokenCutoff(ctx context.Context, userID int) (*time.Time, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.cutoffs[userID]; ok && now.Before(entry.until) {
+		c.mu.Unlock()
+		return entry.cutoff, nil
+	}
+	c.mu.Unlock()
+
+	cutoff, err := c.store.GetUserTokenCutoff(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cutoffs[userID] = cutoffEntry{cutoff: cutoff, until: now.Add(c.ttl)}
+	return cutoff, nil
+}
+
+// sweep drops cache entries that can no longer affect a lookup. Callers must hold c.mu.
+func (c *RevocationCache) sweep(now time.Time) {
+	for jti, expiresAt := range c.revoked {
+		if now.After(expiresAt) {
+			delete(c.revoked, jti)
+		}
+	}
+	for jti, until := range c.checked {
+		if now.After(until) {
+			delete(c.checked, jti)
+		}
+	}
+	for userID, entry := range c.cutoffs {
+		if now.After(entry.until) {
+			delete(c.cutoffs, userID)
+		}
+	}
+}
+
+// RevokeJWT revokes a single access token by its jti claim.
+func RevokeJWT(ctx context.Context, store types.TokenRevocationStore, tokenString string) error {
+	claims, err := validateJWT(tokenString)
+	if err != nil {
+		return types.Unauthorized("invalid_token", "invalid token")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.ID == "" {
+		return types.Unauthorized("invalid_token", "token has no jti or subject claim")
+	}
+
+	return store.RevokeToken(ctx, claims.ID, userID, claims.ExpiresAt.Time)
+}
+
+// RevokeAllSessions invalidates every access token issued to userID so far and
+// every refresh token family the user holds.
+func RevokeAllSessions(ctx context.Context, revocations types.TokenRevocationStore, refreshStore types.RefreshTokenStore, userID int) error {
+	cutoff := sessionCutoff()
+	if err := revokeAllSessions(ctx, revocations, refreshStore, userID, cutoff); err != nil {
+		return err
+	}
+	// tokens issued from here on, such as by a login right after, are past
+	// the cutoff
+	time.Sleep(time.Until(cutoff))
+	return nil
+}
+
+// RotateSessions revokes every session of userID like RevokeAllSessions,
+// including current, the access token of the caller, and returns a new access
+// token for the caller issued after the cutoff. Start the matching refresh
+// token family with IssueRefreshToken.
+func RotateSessions(ctx context.Context, revocations types.TokenRevocationStore, refreshStore types.RefreshTokenStore, userID int, current string) (string, error) {
+	cutoff := sessionCutoff()
+	if err := revokeAllSessions(ctx, revocations, refreshStore, userID, cutoff); err != nil {
+		return "", err
+	}
+	// the cutoff already covers it, but revoking it by jti as well keeps it
+	// revoked whatever happens to the cutoff later
+	if current != "" {
+		if err := RevokeJWT(ctx, revocations, current); err != nil {
+			return "", err
+		}
+	}
+
+	// a token may not be issued in the future, so wait for the cutoff rather
+	// than move it back and let other tokens of this tick survive
+	time.Sleep(time.Until(cutoff))
+	return createJWT(userID, time.Now())
+}
+
+// sessionCutoff returns the end of the current sessionPrecision tick, which
+// revokes every token issued up to now, including within the same tick.
+func sessionCutoff() time.Time {
+	return time.Now().Truncate(sessionPrecision).Add(sessionPrecision)
+}
+
+func revokeAllSessions(ctx context.Context, revocations types.TokenRevocationStore, refreshStore types.RefreshTokenStore, userID int, cutoff time.Time) error {
+	if err := revocations.RevokeUserTokens(ctx, userID, cutoff); err != nil {
+		return err
+	}
+	if err := refreshStore.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "revoked all sessions", "token_user_id", userID)
+	return nil
+}
+
+// isRevoked reports whether the token identified by jti, issued at issuedAt to
+// userID, has been revoked individually or by a log-out-everywhere.
+func isRevoked(ctx context.Context, store types.TokenRevocationStore, jti string, userID int, issuedAt time.Time) (bool, error) {
+	revoked, err := store.IsTokenRevoked(ctx, jti)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	cutoff, err := store.GetUserTokenCutoff(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	// compare at the precision tokens are issued with; iat went through a
+	// float64, so round off the error rather than truncate
+	return cutoff != nil && issuedAt.Round(sessionPrecision).Before(cutoff.Truncate(sessionPrecision)), nil
+}
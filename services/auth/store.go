@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateRefreshToken(ctx context.Context, token types.RefreshToken) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO refresh_token (user_id, family_id, token_hash, expires_at) VALUES (?,?,?,?)",
+		token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) GetRefreshTokenByHash(ctx context.Context, hash string) (*types.RefreshToken, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	token := new(types.RefreshToken)
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at FROM refresh_token WHERE token_hash = ?",
+		hash,
+	).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, types.NotFound("refresh_token_not_found", "refresh token not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks the token usedID as used and stores its successor in
+// one transaction. It returns ErrRefreshTokenReused if usedID was already spent,
+// so two concurrent refreshes cannot both succeed.
+func (s *Store) RotateRefreshToken(ctx context.Context, usedID int, next types.RefreshToken) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE refresh_token SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL",
+		usedID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if affected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO refresh_token (user_id, family_id, token_hash, expires_at) VALUES (?,?,?,?)",
+		next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ? AND revoked_at IS NULL",
+		familyID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO revoked_token (jti, user_id, expires_at) VALUES (?,?,?)",
+		jti, userID, expiresAt.UTC(),
+	)
+	if err != nil && !db.IsDuplicateKey(err) {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+
+	// entries are only useful until the token would have expired anyway
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_token WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		slog.WarnContext(ctx, "purging expired revocations failed", "err", err)
+	}
+	return nil
+}
+
+func (s *Store) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM revoked_token WHERE jti = ?", jti).Scan(&count)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	return count > 0, nil
+}
+
+func (s *Store) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE user_token_cutoff SET revoked_before = ? WHERE user_id = ?", before.UTC(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		return nil
+	}
+
+	_, err = s.db.ExecContext(ctx, "INSERT INTO user_token_cutoff (user_id, revoked_before) VALUES (?,?)", userID, before.UTC())
+	if db.IsDuplicateKey(err) {
+		// a concurrent request already created the row; it holds the same cutoff
+		return nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) GetUserTokenCutoff(ctx context.Context, userID int) (*time.Time, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	var cutoff time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT revoked_before FROM user_token_cutoff WHERE user_id = ?", userID).Scan(&cutoff)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return &cutoff, nil
+}
+
+func (s *Store) CreatePasswordResetToken(ctx context.Context, token types.PasswordResetToken) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO password_reset_token (user_id, token_hash, expires_at) VALUES (?,?,?)",
+		token.UserID, token.TokenHash, token.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) GetPasswordResetTokenByHash(ctx context.Context, hash string) (*types.PasswordResetToken, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	token := new(types.PasswordResetToken)
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, user_id, token_hash, expires_at, used_at, created_at FROM password_reset_token WHERE token_hash = ?",
+		hash,
+	).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return token, nil
+}
+
+// ConsumePasswordResetToken claims the token with a conditional update, so of
+// two concurrent resets with the same link only one succeeds.
+func (s *Store) ConsumePasswordResetToken(ctx context.Context, hash string, now time.Time) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM password_reset_token WHERE token_hash = ?", hash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE password_reset_token SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		now.UTC(), hash, now.UTC(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	if affected == 0 {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	// a reset spends every other link that was sent to the user
+	if _, err := tx.ExecContext(ctx, "UPDATE password_reset_token SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now.UTC(), userID); err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	return userID, nil
+}
+
+func (s *Store) CreatePersonalAccessToken(ctx context.Context, token types.PersonalAccessToken) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO personal_access_token (user_id, name, token_hash, scopes, expires_at) VALUES (?,?,?,?,?)",
+		token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, " "), utcOrNil(token.ExpiresAt),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading last insert ID failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	return int(id), nil
+}
+
+const personalAccessTokenColumns = "id, user_id, name, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func (s *Store) GetPersonalAccessTokens(ctx context.Context, userID int) ([]*types.PersonalAccessToken, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+personalAccessTokenColumns+" FROM personal_access_token WHERE user_id = ? AND revoked_at IS NULL ORDER BY id DESC",
+		userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*types.PersonalAccessToken, 0)
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return tokens, nil
+}
+
+func (s *Store) GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*types.PersonalAccessToken, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	token, err := scanPersonalAccessToken(s.db.QueryRowContext(ctx,
+		"SELECT "+personalAccessTokenColumns+" FROM personal_access_token WHERE token_hash = ?",
+		hash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrPersonalAccessTokenNotFound
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return token, nil
+}
+
+func (s *Store) TouchPersonalAccessToken(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "UPDATE personal_access_token SET last_used_at = ? WHERE id = ?", at.UTC(), id)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) RevokePersonalAccessToken(ctx context.Context, userID int, id int, at time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE personal_access_token SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		at.UTC(), id, userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if affected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+func (s *Store) RevokeUserPersonalAccessTokens(ctx context.Context, userID int, at time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "UPDATE personal_access_token SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", at.UTC(), userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPersonalAccessToken(row rowScanner) (*types.PersonalAccessToken, error) {
+	token := new(types.PersonalAccessToken)
+	var scopes string
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	return token, nil
+}
+
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,31 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/services/storetest"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.RunTokenStoreTests(t, func(t *testing.T) (storetest.TokenStore, types.UserStore) {
+		return auth.NewMemoryStore(), user.NewMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.RunTokenStoreTests(t, func(t *testing.T) (storetest.TokenStore, types.UserStore) {
+		db := storetest.SQLite(t)
+		return auth.NewStore(db), user.NewStore(db)
+	})
+}
+
+func TestMySQLStore(t *testing.T) {
+	storetest.SkipWithoutMySQL(t)
+	storetest.RunTokenStoreTests(t, func(t *testing.T) (storetest.TokenStore, types.UserStore) {
+		db := storetest.MySQL(t)
+		return auth.NewStore(db), user.NewStore(db)
+	})
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TOTP parameters, the defaults of RFC 6238 that every authenticator app
+// supports.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpModulo = 1000000 // 10^totpDigits
+	// totpSkew is how many periods a code may be off by, for clock drift
+	totpSkew = 1
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+// MFATokenTTL is how long the second step of a login may take.
+const MFATokenTTL = 5 * time.Minute
+
+var ErrMFATokenInvalid = types.Unauthorized("invalid_mfa_token", "the login has expired, please login again")
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 secret for an authenticator app.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps scan as a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// MatchTOTP returns the time step code is valid for at now, allowing for a
+// little clock drift. Callers record the step so the code cannot be replayed.
+func MatchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// IsTOTPCode reports whether code looks like a code from an authenticator app
+// rather than a recovery code.
+func IsTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// totpCode computes the HOTP value of RFC 4226 for counter step.
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo)
+}
+
+// NewRecoveryCodes returns a fresh set of recovery codes to show the user
+// once, along with the hashes to store.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		// 10 characters, about 50 bits, grouped to be easy to copy down
+		raw := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored under, ignoring
+// case, spaces and dashes so codes typed back loosely still match.
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	return HashToken("recovery|" + normalized)
+}
+
+// CreateMFAToken signs the short-lived token that proves userID got the
+// password right and may finish logging in with a second factor. Its own
+// audience keeps it from being accepted as an access token.
+func CreateMFAToken(userID int) (string, error) {
+	if Keys == nil {
+		return "", fmt.Errorf("no signing keys configured")
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(userID),
+		Issuer:    Keys.issuer,
+		Audience:  jwt.ClaimStrings{mfaAudience()},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+	}
+	token, err := Keys.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return token, nil
+}
+
+// ParseMFAToken returns the user an unexpired MFA token was issued to.
+func ParseMFAToken(token string) (int, error) {
+	if Keys == nil {
+		return 0, fmt.Errorf("no signing keys configured")
+	}
+	claims := new(jwt.RegisteredClaims)
+	if _, err := Keys.parse(token, claims, mfaAudience()); err != nil {
+		return 0, ErrMFATokenInvalid
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return 0, ErrMFATokenInvalid
+	}
+	return userID, nil
+}
+
+func mfaAudience() string {
+	return Keys.audience + "/mfa"
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of RFC 6238's test vectors, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	key := []byte("12345678901234567890")
+
+	for _, offset := range []int64{-1, 0, 1} {
+		got, ok := MatchTOTP(rfc6238Secret, totpCode(key, step+offset), now)
+		if !ok || got != step+offset {
+			t.Errorf("code %d steps off = %d, %v; want step %d", offset, got, ok, step+offset)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := MatchTOTP(rfc6238Secret, totpCode(key, step+offset), now); ok {
+			t.Errorf("code %d steps off was accepted", offset)
+		}
+	}
+
+	// authenticator apps may show the secret in lower case
+	if _, ok := MatchTOTP(strings.ToLower(rfc6238Secret), "005924", now); !ok {
+		t.Error("lower case secret did not match")
+	}
+	for _, code := range []string{"", "00592", "0059240", "xxxxxx"} {
+		if _, ok := MatchTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("MatchTOTP(%q) matched", code)
+		}
+	}
+	if _, ok := MatchTOTP("not base32!", "005924", now); ok {
+		t.Error("invalid secret matched")
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v; want 20", secret, len(key), err)
+	}
+	if other, _ := NewTOTPSecret(); other == secret {
+		t.Fatal("two secrets are the same")
+	}
+
+	uri := TOTPURI("Todo App", "jane@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Todo%20App:jane@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("TOTPURI = %q", uri)
+	}
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	for code, want := range map[string]bool{
+		"123456":      true,
+		"12345":       false,
+		"1234567":     false,
+		"12345a":      false,
+		"abcde-fghij": false,
+	} {
+		if got := IsTOTPCode(code); got != want {
+			t.Errorf("IsTOTPCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+			t.Errorf("code %q, want two groups of five lower case characters", code)
+		}
+		// recovery codes must never be taken for an authenticator code
+		if IsTOTPCode(code) {
+			t.Errorf("code %q looks like an authenticator code", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q repeats", code)
+		}
+		seen[code] = true
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash of %q does not match", code)
+		}
+	}
+
+	// codes typed back loosely still match
+	for _, typed := range []string{"abcdefghij", "ABCDE-FGHIJ", "abcde fghij", " abcde-fghij "} {
+		if HashRecoveryCode(typed) != HashRecoveryCode("abcde-fghij") {
+			t.Errorf("HashRecoveryCode(%q) differs", typed)
+		}
+	}
+	if HashRecoveryCode("abcde-fghik") == HashRecoveryCode("abcde-fghij") {
+		t.Error("different codes hash the same")
+	}
+}
+
+func TestMFAToken(t *testing.T) {
+	useKeys(t, KeyConfig{Secret: "secret"})
+
+	token, err := CreateMFAToken(7)
+	if err != nil {
+		t.Fatalf("CreateMFAToken: %v", err)
+	}
+	if userID, err := ParseMFAToken(token); err != nil || userID != 7 {
+		t.Fatalf("ParseMFAToken = %d, %v; want 7", userID, err)
+	}
+
+	// a password alone must not make an access token, nor the other way round
+	if _, err := validateJWT(token); err == nil {
+		t.Error("MFA token is accepted as an access token")
+	}
+	access, err := CreateJWT(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMFAToken(access); err == nil {
+		t.Error("access token is accepted as an MFA token")
+	}
+}
+
+func TestSecretCipher(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	newKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))
+
+	old, err := NewSecretCipher(oldKey, nil)
+	if err != nil {
+		t.Fatalf("NewSecretCipher: %v", err)
+	}
+	ciphertext, err := old.Encrypt(7, "secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if strings.Contains(ciphertext, "secret") {
+		t.Fatalf("ciphertext %q contains the plaintext", ciphertext)
+	}
+	if other, _ := old.Encrypt(7, "secret"); other == ciphertext {
+		t.Fatal("encrypting twice gave the same ciphertext")
+	}
+	if plaintext, err := old.Decrypt(7, ciphertext); err != nil || plaintext != "secret" {
+		t.Fatalf("Decrypt = %q, %v; want secret", plaintext, err)
+	}
+	// copied onto another user's row, the secret is useless
+	if _, err := old.Decrypt(8, ciphertext); err == nil {
+		t.Fatal("ciphertext decrypted for another user")
+	}
+
+	rotated, err := NewSecretCipher(newKey, []string{oldKey})
+	if err != nil {
+		t.Fatalf("NewSecretCipher: %v", err)
+	}
+	if rotated.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", rotated.Len())
+	}
+	if plaintext, err := rotated.Decrypt(7, ciphertext); err != nil || plaintext != "secret" {
+		t.Fatalf("Decrypt with the previous key = %q, %v; want secret", plaintext, err)
+	}
+	newCiphertext, err := rotated.Encrypt(7, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Decrypt(7, newCiphertext); err == nil {
+		t.Fatal("ciphertext under the new key decrypted with only the old key")
+	}
+
+	for _, ciphertext := range []string{"", "nodot", "unknown.AAAA", ciphertext[:strings.Index(ciphertext, ".")] + ".AA"} {
+		if _, err := rotated.Decrypt(7, ciphertext); err == nil {
+			t.Errorf("Decrypt(%q) succeeded", ciphertext)
+		}
+	}
+
+	for _, key := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewSecretCipher(key, nil); err == nil {
+			t.Errorf("NewSecretCipher(%q) succeeded", key)
+		}
+	}
+	if _, err := NewSecretCipher(newKey, []string{"short"}); err == nil {
+		t.Error("NewSecretCipher with a bad previous key succeeded")
+	}
+}
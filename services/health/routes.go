@@ -0,0 +1,180 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/utils"
+	"github.com/gorilla/mux"
+)
+
+// checkTimeout bounds each dependency check so a hung database cannot hang
+// the probe as well.
+const checkTimeout = 2 * time.Second
+
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusSkipped = "skipped"
+)
+
+// Component is the state of a single dependency.
+type Component struct {
+	Status    string         `json:"status"`
+	LatencyMS int64          `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// Report is the body of /readyz.
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+type Handler struct {
+	db      *sql.DB
+	started time.Time
+
+	// expected is the newest migration shipped with the binary
+	expected    uint
+	expectedErr error
+}
+
+// NewHandler builds the probes for db, which is nil for in-memory storage.
+// migrations holds the migration files the database should be up to date with.
+func NewHandler(db *sql.DB, migrations fs.FS) *Handler {
+	h := &Handler{db: db, started: time.Now()}
+	if db != nil {
+		h.expected, h.expectedErr = latestVersion(migrations)
+		if h.expectedErr != nil {
+			slog.Error("reading migrations failed", "err", h.expectedErr)
+		}
+	}
+	return h
+}
+
+func latestVersion(migrations fs.FS) (uint, error) {
+	if migrations == nil {
+		return 0, fmt.Errorf("no migrations configured")
+	}
+	return db.LatestMigrationVersion(migrations)
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/healthz", h.handleHealthz).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", h.handleReadyz).Methods("GET", "HEAD")
+}
+
+// handleHealthz only reports that the process is serving requests.
+func (h *Handler) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, struct {
+		Status        string `json:"status"`
+		UptimeSeconds int64  `json:"uptime_seconds"`
+	}{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+	})
+}
+
+// handleReadyz checks every dependency and answers 503 unless all are up.
+func (h *Handler) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	utils.WriteJSON(w, status, report)
+}
+
+// Check runs the dependency checks and collects their states.
+func (h *Handler) Check(ctx context.Context) Report {
+	report := Report{Status: "ok", Components: map[string]Component{}}
+
+	if h.db == nil {
+		skipped := Component{Status: StatusSkipped, Details: map[string]any{"storage": "memory"}}
+		report.Components["database"] = skipped
+		report.Components["migrations"] = skipped
+		return report
+	}
+
+	database := h.checkDatabase(ctx)
+	report.Components["database"] = database
+
+	if database.Status == StatusUp {
+		report.Components["migrations"] = h.checkMigrations(ctx)
+	} else {
+		report.Components["migrations"] = Component{Status: StatusDown, Error: "database unavailable"}
+	}
+
+	for _, component := range report.Components {
+		if component.Status == StatusDown {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+func (h *Handler) checkDatabase(ctx context.Context) Component {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.db.PingContext(ctx)
+	component := Component{Status: StatusUp, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.WarnContext(ctx, "readiness: database ping failed", "err", err)
+		component.Status = StatusDown
+		component.Error = describe(ctx)
+	}
+	return component
+}
+
+func (h *Handler) checkMigrations(ctx context.Context) Component {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	version, dirty, err := db.MigrationVersion(ctx, h.db)
+	component := Component{
+		Status:    StatusUp,
+		LatencyMS: time.Since(start).Milliseconds(),
+		Details: map[string]any{
+			"version":  version,
+			"expected": h.expected,
+			"dirty":    dirty,
+		},
+	}
+
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "readiness: reading migration version failed", "err", err)
+		component.Status = StatusDown
+		component.Error = describe(ctx)
+	case h.expectedErr != nil:
+		component.Status = StatusDown
+		component.Error = "expected migration version unknown"
+	case dirty:
+		component.Status = StatusDown
+		component.Error = "last migration failed"
+	case version != h.expected:
+		component.Status = StatusDown
+		component.Error = fmt.Sprintf("schema is at version %d, expected %d", version, h.expected)
+	}
+	return component
+}
+
+// describe keeps driver errors out of the public report.
+func describe(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return "timed out"
+	}
+	return "check failed"
+}
@@ -0,0 +1,95 @@
+package storetest
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLDSNEnv names the variable pointing the tests at a MySQL database, e.g.
+// "user:password@tcp(localhost:3306)/todo_test". The database must be migrated
+// with `make migrate-up` and is emptied by every test, so never point it at
+// real data. Packages share it, so run them one at a time with `go test -p 1`.
+const MySQLDSNEnv = "TEST_MYSQL_DSN"
+
+// SQLite returns a fresh, migrated in-memory SQLite database that is closed
+// when t ends.
+func SQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	d, err := db.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// SkipWithoutMySQL skips t unless MySQLDSNEnv is set.
+func SkipWithoutMySQL(t *testing.T) {
+	t.Helper()
+	if os.Getenv(MySQLDSNEnv) == "" {
+		t.Skipf("%s is not set", MySQLDSNEnv)
+	}
+}
+
+// MySQL returns the database MySQLDSNEnv points at with every table emptied,
+// and skips t when the variable is not set.
+func MySQL(t *testing.T) *sql.DB {
+	t.Helper()
+	SkipWithoutMySQL(t)
+	cfg, err := mysql.ParseDSN(os.Getenv(MySQLDSNEnv))
+	if err != nil {
+		t.Fatalf("parsing %s: %v", MySQLDSNEnv, err)
+	}
+	// the stores scan timestamps into time.Time
+	cfg.ParseTime = true
+
+	d, err := db.MyNewSQLStorage(*cfg)
+	if err != nil {
+		t.Fatalf("opening mysql: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	truncateTables(t, d)
+	return d
+}
+
+// truncateTables empties every table but the migration bookkeeping. Foreign key
+// checks are a per-connection setting, so it runs on a single connection.
+func truncateTables(t *testing.T, d *sql.DB) {
+	t.Helper()
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		t.Fatalf("connecting to mysql: %v", err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'")
+	if err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatalf("listing tables: %v", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		t.Fatalf("disabling foreign key checks: %v", err)
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE `"+table+"`"); err != nil {
+			t.Fatalf("emptying %s: %v", table, err)
+		}
+	}
+}
@@ -0,0 +1,1007 @@
+// Package storetest is a conformance suite shared by every storage backend.
+// Each implementation runs it from its package's store_test.go against a fresh
+// store, so the MySQL, SQLite and in-memory stores stay interchangeable.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+var ctx = context.Background()
+
+// UserStoreFactory returns an empty UserStore.
+type UserStoreFactory func(t *testing.T) types.UserStore
+
+// TodoStoreFactory returns an empty TodoStore together with the UserStore its todos belong to.
+type TodoStoreFactory func(t *testing.T) (types.TodoStore, types.UserStore)
+
+// TokenStoreFactory returns an empty token store together with the UserStore its tokens belong to.
+type TokenStoreFactory func(t *testing.T) (TokenStore, types.UserStore)
+
+// LoginAttemptStoreFactory returns an empty LoginAttemptStore together with the UserStore its attempts belong to.
+type LoginAttemptStoreFactory func(t *testing.T) (types.LoginAttemptStore, types.UserStore)
+
+// IdentityStoreFactory returns an empty UserIdentityStore together with the UserStore its identities belong to.
+type IdentityStoreFactory func(t *testing.T) (types.UserIdentityStore, types.UserStore)
+
+// MFAStoreFactory returns an empty MFAStore together with the UserStore its secrets belong to.
+type MFAStoreFactory func(t *testing.T) (types.MFAStore, types.UserStore)
+
+// TokenStore is implemented by the stores that keep refresh tokens, revocations,
+// password reset tokens and personal access tokens.
+type TokenStore interface {
+	types.RefreshTokenStore
+	types.TokenRevocationStore
+	types.PasswordResetStore
+	types.PersonalAccessTokenStore
+}
+
+func RunUserStoreTests(t *testing.T, newStore UserStoreFactory) {
+	t.Run("CreateAndGetByID", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+
+		user, err := store.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if user.ID != id || user.UserName != "jane" || user.Email != "jane@example.com" {
+			t.Fatalf("GetUserByID returned %+v", user)
+		}
+		if user.CreatedAt.IsZero() {
+			t.Fatal("CreatedAt was not set")
+		}
+	})
+
+	t.Run("GetByEmailOrUsername", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+
+		for _, login := range []string{"jane@example.com", "jane"} {
+			user, err := store.GetUserByLogin(ctx, login)
+			if err != nil {
+				t.Fatalf("GetUserByLogin(%q): %v", login, err)
+			}
+			if user.ID != id {
+				t.Fatalf("GetUserByLogin(%q) returned user %d, want %d", login, user.ID, id)
+			}
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetUserByID(ctx, 12345); err == nil {
+			t.Fatal("GetUserByID of a missing user returned no error")
+		}
+		if _, err := store.GetUserByEmail(ctx, "nobody@example.com"); err == nil {
+			t.Fatal("GetUserByEmail of a missing user returned no error")
+		}
+	})
+
+	t.Run("MarkEmailVerified", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.EmailVerifiedAt != nil {
+			t.Fatalf("new user = %+v, %v; want EmailVerifiedAt unset", user, err)
+		}
+
+		if verified, err := store.MarkEmailVerified(ctx, id, "other@example.com", time.Now()); err != nil || verified {
+			t.Fatalf("MarkEmailVerified with a stale address = %v, %v; want false", verified, err)
+		}
+		if verified, err := store.MarkEmailVerified(ctx, id, "jane@example.com", time.Now()); err != nil || !verified {
+			t.Fatalf("MarkEmailVerified = %v, %v; want true", verified, err)
+		}
+		if verified, err := store.MarkEmailVerified(ctx, id, "jane@example.com", time.Now()); err != nil || verified {
+			t.Fatalf("second MarkEmailVerified = %v, %v; want false", verified, err)
+		}
+
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.EmailVerifiedAt == nil {
+			t.Fatalf("verified user = %+v, %v; want EmailVerifiedAt set", user, err)
+		}
+	})
+
+	t.Run("UpdatePassword", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+
+		if err := store.UpdatePassword(ctx, id, "new-hash"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.Password != "new-hash" {
+			t.Fatalf("user after UpdatePassword = %+v, %v", user, err)
+		}
+		if err := store.UpdatePassword(ctx, 12345, "hash"); err == nil {
+			t.Fatal("UpdatePassword of a missing user returned no error")
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+		createUser(t, store, "john")
+
+		firstName, userName := "Janet", "janet"
+		user, err := store.UpdateUser(ctx, id, types.UserUpdatePayload{FirstName: &firstName, UserName: &userName})
+		if err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if user.FirstName != "Janet" || user.LastName != "User" || user.UserName != "janet" {
+			t.Fatalf("updated user = %+v", user)
+		}
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.UserName != "janet" {
+			t.Fatalf("user after UpdateUser = %+v, %v", user, err)
+		}
+
+		for _, taken := range []string{"john", "JOHN"} {
+			_, err := store.UpdateUser(ctx, id, types.UserUpdatePayload{UserName: &taken})
+			if !errors.Is(err, types.ErrConflict) {
+				t.Fatalf("UpdateUser to username %q = %v, want a conflict", taken, err)
+			}
+		}
+		// keeping your own username is not a conflict
+		if _, err := store.UpdateUser(ctx, id, types.UserUpdatePayload{UserName: &userName}); err != nil {
+			t.Fatalf("UpdateUser to the same username: %v", err)
+		}
+		if _, err := store.UpdateUser(ctx, 12345, types.UserUpdatePayload{FirstName: &firstName}); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("UpdateUser of a missing user = %v, want not found", err)
+		}
+	})
+
+	t.Run("DeleteRestoreAndPurge", func(t *testing.T) {
+		store := newStore(t)
+		id := createUser(t, store, "jane")
+		other := createUser(t, store, "john")
+
+		deletedAt := time.Now().Add(-time.Hour)
+		if err := store.DeleteUser(ctx, id, deletedAt); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if err := store.DeleteUser(ctx, id, deletedAt); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("deleting a deleted user = %v, want not found", err)
+		}
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.DeletedAt == nil {
+			t.Fatalf("deleted user = %+v, %v; want DeletedAt set", user, err)
+		}
+
+		ids, err := store.GetUsersDeletedBefore(ctx, time.Now(), 10)
+		if err != nil || len(ids) != 1 || ids[0] != id {
+			t.Fatalf("GetUsersDeletedBefore(now) = %v, %v; want [%d]", ids, err, id)
+		}
+		if ids, err := store.GetUsersDeletedBefore(ctx, deletedAt.Add(-time.Minute), 10); err != nil || len(ids) != 0 {
+			t.Fatalf("GetUsersDeletedBefore(before deletion) = %v, %v; want none", ids, err)
+		}
+
+		if err := store.RestoreUser(ctx, id); err != nil {
+			t.Fatalf("RestoreUser: %v", err)
+		}
+		if user, err := store.GetUserByID(ctx, id); err != nil || user.DeletedAt != nil {
+			t.Fatalf("restored user = %+v, %v; want DeletedAt cleared", user, err)
+		}
+
+		// listed for purging before the restore, the user is kept all the same
+		if purged, err := store.PurgeUser(ctx, id, time.Now()); err != nil || purged {
+			t.Fatalf("PurgeUser of a restored user = %v, %v; want kept", purged, err)
+		}
+		if _, err := store.GetUserByID(ctx, id); err != nil {
+			t.Fatalf("restored user lookup after purge: %v", err)
+		}
+
+		// stores keep whole seconds, so the deletion time is exactly this
+		deletedAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+		if err := store.DeleteUser(ctx, id, deletedAt); err != nil {
+			t.Fatalf("DeleteUser after restoring: %v", err)
+		}
+		if purged, err := store.PurgeUser(ctx, id, deletedAt); err != nil || purged {
+			t.Fatalf("PurgeUser within the grace period = %v, %v; want kept", purged, err)
+		}
+		if purged, err := store.PurgeUser(ctx, other, time.Now()); err != nil || purged {
+			t.Fatalf("PurgeUser of a user never deleted = %v, %v; want kept", purged, err)
+		}
+		if purged, err := store.PurgeUser(ctx, id, time.Now()); err != nil || !purged {
+			t.Fatalf("PurgeUser = %v, %v; want purged", purged, err)
+		}
+		if _, err := store.GetUserByID(ctx, id); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("purged user lookup = %v, want not found", err)
+		}
+		if _, err := store.GetUserByID(ctx, other); err != nil {
+			t.Fatalf("PurgeUser removed another user: %v", err)
+		}
+	})
+
+	t.Run("Lookups", func(t *testing.T) {
+		store := newStore(t)
+		jane := createUser(t, store, "jane")
+		john := createUser(t, store, "john")
+
+		lookups := []struct {
+			name   string
+			lookup func() (*types.User, error)
+			want   int
+		}{
+			{"GetUserByEmail", func() (*types.User, error) { return store.GetUserByEmail(ctx, " Jane@Example.COM ") }, jane},
+			{"GetUserByUsername", func() (*types.User, error) { return store.GetUserByUsername(ctx, "JOHN") }, john},
+			{"GetUserByLogin", func() (*types.User, error) { return store.GetUserByLogin(ctx, "Jane") }, jane},
+		}
+		for _, tc := range lookups {
+			user, err := tc.lookup()
+			if err != nil || user.ID != tc.want {
+				t.Fatalf("%s = %+v, %v; want user %d", tc.name, user, err, tc.want)
+			}
+		}
+
+		// an email is never looked up as a username, nor the other way round
+		if _, err := store.GetUserByUsername(ctx, "jane@example.com"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetUserByUsername(email) = %v, want not found", err)
+		}
+		if _, err := store.GetUserByLogin(ctx, "nobody@example.com"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetUserByLogin of a missing user = %v, want not found", err)
+		}
+	})
+
+	t.Run("DuplicateEmail", func(t *testing.T) {
+		store := newStore(t)
+		createUser(t, store, "jane")
+		_, err := store.CreateUser(ctx, types.User{
+			FirstName: "Other",
+			LastName:  "User",
+			UserName:  "other",
+			Email:     "Jane@Example.com",
+			Password:  "hash",
+		})
+		assertConflict(t, err, "email")
+	})
+
+	t.Run("DuplicateUsername", func(t *testing.T) {
+		store := newStore(t)
+		createUser(t, store, "jane")
+		_, err := store.CreateUser(ctx, types.User{
+			FirstName: "Other",
+			LastName:  "User",
+			UserName:  "JANE",
+			Email:     "other@example.com",
+			Password:  "hash",
+		})
+		assertConflict(t, err, "username")
+	})
+}
+
+func RunTodoStoreTests(t *testing.T, newStores TodoStoreFactory) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		todos, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		createTodos(t, todos, userID, "write tests")
+
+		page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10})
+		if page.Total != 1 || len(page.Todos) != 1 {
+			t.Fatalf("GetTodos returned %d of %d todos, want 1 of 1", len(page.Todos), page.Total)
+		}
+		created := page.Todos[0]
+		if created.Title != "write tests" || created.Status != "pending" || created.UserID != userID {
+			t.Fatalf("GetTodos returned %+v", created)
+		}
+
+		todo, err := todos.GetTodoByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("GetTodoByID: %v", err)
+		}
+		if todo.Title != created.Title {
+			t.Fatalf("GetTodoByID returned %+v, want %+v", todo, created)
+		}
+	})
+
+	t.Run("TodosAreScopedToUser", func(t *testing.T) {
+		todos, users := newStores(t)
+		jane := createUser(t, users, "jane")
+		john := createUser(t, users, "john")
+		createTodos(t, todos, jane, "a", "b")
+		createTodos(t, todos, john, "c")
+
+		if page := listTodos(t, todos, jane, types.TodoQuery{Limit: 10}); page.Total != 2 {
+			t.Fatalf("jane has %d todos, want 2", page.Total)
+		}
+		if page := listTodos(t, todos, john, types.TodoQuery{Limit: 10}); page.Total != 1 {
+			t.Fatalf("john has %d todos, want 1", page.Total)
+		}
+	})
+
+	t.Run("PartialUpdate", func(t *testing.T) {
+		todos, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		createTodos(t, todos, userID, "typo")
+		id := listTodos(t, todos, userID, types.TodoQuery{Limit: 1}).Todos[0].ID
+
+		title, status := "fixed", "completed"
+		updated, err := todos.UpdateTodo(ctx, id, types.TodoUpdatePayload{Title: &title, Status: &status})
+		if err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+		if updated.Title != "fixed" || updated.Status != "completed" || updated.Description != "typo description" {
+			t.Fatalf("UpdateTodo returned %+v", updated)
+		}
+
+		if _, err := todos.UpdateTodo(ctx, 12345, types.TodoUpdatePayload{Title: &title}); err == nil {
+			t.Fatal("UpdateTodo of a missing todo returned no error")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		todos, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		createTodos(t, todos, userID, "gone")
+		id := listTodos(t, todos, userID, types.TodoQuery{Limit: 1}).Todos[0].ID
+
+		if err := todos.DeleteTodo(ctx, id); err != nil {
+			t.Fatalf("DeleteTodo: %v", err)
+		}
+		if _, err := todos.GetTodoByID(ctx, id); err == nil {
+			t.Fatal("GetTodoByID of a deleted todo returned no error")
+		}
+	})
+
+	t.Run("DeleteUserTodos", func(t *testing.T) {
+		todos, users := newStores(t)
+		jane := createUser(t, users, "jane")
+		john := createUser(t, users, "john")
+		createTodos(t, todos, jane, "one", "two")
+		createTodos(t, todos, john, "three")
+
+		deleted, err := todos.DeleteUserTodos(ctx, jane)
+		if err != nil || deleted != 2 {
+			t.Fatalf("DeleteUserTodos = %d, %v; want 2", deleted, err)
+		}
+		if page := listTodos(t, todos, jane, types.TodoQuery{Limit: 10}); page.Total != 0 {
+			t.Fatalf("%d todos left after DeleteUserTodos, want 0", page.Total)
+		}
+		if page := listTodos(t, todos, john, types.TodoQuery{Limit: 10}); page.Total != 1 {
+			t.Fatalf("other user has %d todos after DeleteUserTodos, want 1", page.Total)
+		}
+	})
+
+	t.Run("FilterAndSearch", func(t *testing.T) {
+		todos, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		createTodos(t, todos, userID, "buy milk", "buy 50% off bread", "call mom")
+		first := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, SortBy: "id"}).Todos[0]
+		status := "completed"
+		if _, err := todos.UpdateTodo(ctx, first.ID, types.TodoUpdatePayload{Status: &status}); err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+
+		if page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, Status: "pending"}); page.Total != 2 {
+			t.Fatalf("%d pending todos, want 2", page.Total)
+		}
+		if page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, Search: "BUY"}); page.Total != 2 {
+			t.Fatalf("%d todos matching BUY, want 2", page.Total)
+		}
+		if page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, Search: "50%"}); page.Total != 1 {
+			t.Fatalf("%d todos matching 50%%, want 1", page.Total)
+		}
+
+		future := time.Now().Add(time.Hour)
+		if page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, CreatedAfter: &future}); page.Total != 0 {
+			t.Fatalf("%d todos created in the future, want 0", page.Total)
+		}
+		if page := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, CreatedBefore: &future}); page.Total != 3 {
+			t.Fatalf("%d todos created before now, want 3", page.Total)
+		}
+	})
+
+	t.Run("SortAndPaginate", func(t *testing.T) {
+		todos, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		createTodos(t, todos, userID, "c", "a", "e", "b", "d")
+
+		for _, sortBy := range []string{"title", "created_at", "id"} {
+			for _, desc := range []bool{false, true} {
+				all := listTodos(t, todos, userID, types.TodoQuery{Limit: 10, SortBy: sortBy, SortDesc: desc})
+
+				// walk the same ordering two at a time by cursor and by offset
+				var byCursor, byOffset []string
+				query := types.TodoQuery{Limit: 2, SortBy: sortBy, SortDesc: desc}
+				for {
+					page := listTodos(t, todos, userID, query)
+					for _, todo := range page.Todos {
+						byCursor = append(byCursor, todo.Title)
+					}
+					if page.NextCursor == nil {
+						break
+					}
+					query.Cursor = page.NextCursor
+				}
+				for offset := 0; offset < 5; offset += 2 {
+					page := listTodos(t, todos, userID, types.TodoQuery{Limit: 2, Offset: offset, SortBy: sortBy, SortDesc: desc})
+					if page.Total != 5 {
+						t.Fatalf("total %d with offset, want 5", page.Total)
+					}
+					for _, todo := range page.Todos {
+						byOffset = append(byOffset, todo.Title)
+					}
+				}
+
+				want := titles(all.Todos)
+				if fmt.Sprint(byCursor) != fmt.Sprint(want) || fmt.Sprint(byOffset) != fmt.Sprint(want) {
+					t.Fatalf("sort %s desc=%v: cursor pages %v, offset pages %v, want %v", sortBy, desc, byCursor, byOffset, want)
+				}
+				if sortBy == "title" {
+					expected := "[a b c d e]"
+					if desc {
+						expected = "[e d c b a]"
+					}
+					if fmt.Sprint(want) != expected {
+						t.Fatalf("sort title desc=%v returned %v, want %v", desc, want, expected)
+					}
+				}
+			}
+		}
+	})
+}
+
+func RunTokenStoreTests(t *testing.T, newStores TokenStoreFactory) {
+	t.Run("RefreshTokenRotation", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		first := types.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: "hash-1", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := store.CreateRefreshToken(ctx, first); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		current, err := store.GetRefreshTokenByHash(ctx, "hash-1")
+		if err != nil {
+			t.Fatalf("GetRefreshTokenByHash: %v", err)
+		}
+		if current.UserID != userID || current.UsedAt != nil || current.RevokedAt != nil {
+			t.Fatalf("GetRefreshTokenByHash returned %+v", current)
+		}
+
+		next := types.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: "hash-2", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := store.RotateRefreshToken(ctx, current.ID, next); err != nil {
+			t.Fatalf("RotateRefreshToken: %v", err)
+		}
+		if err := store.RotateRefreshToken(ctx, current.ID, types.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: "hash-3", ExpiresAt: time.Now().Add(time.Hour)}); err == nil {
+			t.Fatal("rotating an already used token returned no error")
+		}
+
+		used, err := store.GetRefreshTokenByHash(ctx, "hash-1")
+		if err != nil || used.UsedAt == nil {
+			t.Fatalf("used token = %+v, %v; want UsedAt set", used, err)
+		}
+
+		if err := store.RevokeRefreshTokenFamily(ctx, "family"); err != nil {
+			t.Fatalf("RevokeRefreshTokenFamily: %v", err)
+		}
+		revoked, err := store.GetRefreshTokenByHash(ctx, "hash-2")
+		if err != nil || revoked.RevokedAt == nil {
+			t.Fatalf("revoked token = %+v, %v; want RevokedAt set", revoked, err)
+		}
+
+		if _, err := store.GetRefreshTokenByHash(ctx, "missing"); err == nil {
+			t.Fatal("GetRefreshTokenByHash of a missing token returned no error")
+		}
+	})
+
+	t.Run("RevokeUserRefreshTokens", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		for i, family := range []string{"a", "b"} {
+			token := types.RefreshToken{UserID: userID, FamilyID: family, TokenHash: fmt.Sprintf("hash-%d", i), ExpiresAt: time.Now().Add(time.Hour)}
+			if err := store.CreateRefreshToken(ctx, token); err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+		}
+		if err := store.RevokeUserRefreshTokens(ctx, userID); err != nil {
+			t.Fatalf("RevokeUserRefreshTokens: %v", err)
+		}
+		for _, hash := range []string{"hash-0", "hash-1"} {
+			token, err := store.GetRefreshTokenByHash(ctx, hash)
+			if err != nil || token.RevokedAt == nil {
+				t.Fatalf("token %s = %+v, %v; want RevokedAt set", hash, token, err)
+			}
+		}
+	})
+
+	t.Run("AccessTokenRevocation", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if revoked, err := store.IsTokenRevoked(ctx, "jti-1"); err != nil || revoked {
+			t.Fatalf("IsTokenRevoked before revocation = %v, %v", revoked, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.RevokeToken(ctx, "jti-1", userID, time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("RevokeToken #%d: %v", i+1, err)
+			}
+		}
+		if revoked, err := store.IsTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+			t.Fatalf("IsTokenRevoked after revocation = %v, %v", revoked, err)
+		}
+	})
+
+	t.Run("UserTokenCutoff", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if cutoff, err := store.GetUserTokenCutoff(ctx, userID); err != nil || cutoff != nil {
+			t.Fatalf("GetUserTokenCutoff before revocation = %v, %v", cutoff, err)
+		}
+
+		for _, before := range []time.Time{time.Now().Add(-time.Hour), time.Now()} {
+			// cutoffs keep the millisecond precision tokens are issued with
+			before = before.Truncate(time.Millisecond)
+			if err := store.RevokeUserTokens(ctx, userID, before); err != nil {
+				t.Fatalf("RevokeUserTokens: %v", err)
+			}
+			cutoff, err := store.GetUserTokenCutoff(ctx, userID)
+			if err != nil || cutoff == nil || !cutoff.Equal(before) {
+				t.Fatalf("GetUserTokenCutoff = %v, %v; want %v", cutoff, err, before)
+			}
+		}
+	})
+
+	runPasswordResetTests(t, newStores)
+	runPersonalAccessTokenTests(t, newStores)
+}
+
+func runPasswordResetTests(t *testing.T, newStores TokenStoreFactory) {
+	t.Run("PasswordResetTokenIsSingleUse", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		now := time.Now()
+
+		for _, hash := range []string{"reset-1", "reset-2"} {
+			token := types.PasswordResetToken{UserID: userID, TokenHash: hash, ExpiresAt: now.Add(time.Hour)}
+			if err := store.CreatePasswordResetToken(ctx, token); err != nil {
+				t.Fatalf("CreatePasswordResetToken: %v", err)
+			}
+		}
+
+		token, err := store.GetPasswordResetTokenByHash(ctx, "reset-1")
+		if err != nil || token.UserID != userID || token.UsedAt != nil || token.ExpiresAt.IsZero() {
+			t.Fatalf("GetPasswordResetTokenByHash = %+v, %v", token, err)
+		}
+
+		if id, err := store.ConsumePasswordResetToken(ctx, "reset-1", now); err != nil || id != userID {
+			t.Fatalf("ConsumePasswordResetToken = %d, %v; want %d", id, err, userID)
+		}
+		if _, err := store.ConsumePasswordResetToken(ctx, "reset-1", now); err == nil {
+			t.Fatal("consuming a used reset token returned no error")
+		}
+		if _, err := store.ConsumePasswordResetToken(ctx, "reset-2", now); err == nil {
+			t.Fatal("consuming a second reset token of the same user returned no error")
+		}
+		if _, err := store.ConsumePasswordResetToken(ctx, "missing", now); err == nil {
+			t.Fatal("consuming a missing reset token returned no error")
+		}
+		if token, err := store.GetPasswordResetTokenByHash(ctx, "reset-2"); err != nil || token.UsedAt == nil {
+			t.Fatalf("spent reset token = %+v, %v; want UsedAt set", token, err)
+		}
+		if _, err := store.GetPasswordResetTokenByHash(ctx, "missing"); err == nil {
+			t.Fatal("GetPasswordResetTokenByHash of a missing token returned no error")
+		}
+	})
+
+	t.Run("PasswordResetTokenExpires", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		now := time.Now()
+
+		token := types.PasswordResetToken{UserID: userID, TokenHash: "reset", ExpiresAt: now.Add(-time.Minute)}
+		if err := store.CreatePasswordResetToken(ctx, token); err != nil {
+			t.Fatalf("CreatePasswordResetToken: %v", err)
+		}
+		if _, err := store.ConsumePasswordResetToken(ctx, "reset", now); err == nil {
+			t.Fatal("consuming an expired reset token returned no error")
+		}
+	})
+}
+
+func runPersonalAccessTokenTests(t *testing.T, newStores TokenStoreFactory) {
+	t.Run("PersonalAccessTokens", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+		otherID := createUser(t, users, "john")
+		expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+		ciID, err := store.CreatePersonalAccessToken(ctx, types.PersonalAccessToken{
+			UserID: userID, Name: "ci", TokenHash: "pat-ci", Scopes: []string{"todos:read", "todos:write"}, ExpiresAt: &expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("CreatePersonalAccessToken: %v", err)
+		}
+		backupID, err := store.CreatePersonalAccessToken(ctx, types.PersonalAccessToken{
+			UserID: userID, Name: "backup", TokenHash: "pat-backup", Scopes: []string{"todos:read"},
+		})
+		if err != nil {
+			t.Fatalf("CreatePersonalAccessToken: %v", err)
+		}
+
+		token, err := store.GetPersonalAccessTokenByHash(ctx, "pat-ci")
+		if err != nil || token.ID != ciID || token.UserID != userID || token.Name != "ci" || token.CreatedAt.IsZero() {
+			t.Fatalf("GetPersonalAccessTokenByHash = %+v, %v", token, err)
+		}
+		if fmt.Sprint(token.Scopes) != "[todos:read todos:write]" || token.ExpiresAt == nil || !token.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("token scopes %v and expiry %v were not kept", token.Scopes, token.ExpiresAt)
+		}
+		if token.LastUsedAt != nil || token.RevokedAt != nil {
+			t.Fatalf("new token is %+v, want it unused and active", token)
+		}
+		if _, err := store.GetPersonalAccessTokenByHash(ctx, "missing"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetPersonalAccessTokenByHash of a missing token = %v, want not found", err)
+		}
+
+		usedAt := time.Now().UTC().Truncate(time.Second)
+		if err := store.TouchPersonalAccessToken(ctx, ciID, usedAt); err != nil {
+			t.Fatalf("TouchPersonalAccessToken: %v", err)
+		}
+		if token, err := store.GetPersonalAccessTokenByHash(ctx, "pat-ci"); err != nil || token.LastUsedAt == nil || !token.LastUsedAt.Equal(usedAt) {
+			t.Fatalf("touched token = %+v, %v; want LastUsedAt %v", token, err, usedAt)
+		}
+
+		tokens, err := store.GetPersonalAccessTokens(ctx, userID)
+		if err != nil || len(tokens) != 2 || tokens[0].ID != backupID || tokens[1].ID != ciID {
+			t.Fatalf("GetPersonalAccessTokens = %d tokens, %v; want backup then ci", len(tokens), err)
+		}
+
+		if err := store.RevokePersonalAccessToken(ctx, otherID, ciID, time.Now()); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("revoking another user's token = %v, want not found", err)
+		}
+		if err := store.RevokePersonalAccessToken(ctx, userID, ciID, time.Now()); err != nil {
+			t.Fatalf("RevokePersonalAccessToken: %v", err)
+		}
+		if err := store.RevokePersonalAccessToken(ctx, userID, ciID, time.Now()); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("revoking a token twice = %v, want not found", err)
+		}
+		if token, err := store.GetPersonalAccessTokenByHash(ctx, "pat-ci"); err != nil || token.RevokedAt == nil {
+			t.Fatalf("revoked token = %+v, %v; want RevokedAt set", token, err)
+		}
+		if tokens, err := store.GetPersonalAccessTokens(ctx, userID); err != nil || len(tokens) != 1 || tokens[0].ID != backupID {
+			t.Fatalf("GetPersonalAccessTokens after revoking = %d tokens, %v; want only backup", len(tokens), err)
+		}
+
+		// revoking all of a user's tokens leaves other users' alone
+		if _, err := store.CreatePersonalAccessToken(ctx, types.PersonalAccessToken{
+			UserID: otherID, Name: "other", TokenHash: "pat-other", Scopes: []string{"todos:read"},
+		}); err != nil {
+			t.Fatalf("CreatePersonalAccessToken: %v", err)
+		}
+		if err := store.RevokeUserPersonalAccessTokens(ctx, userID, time.Now()); err != nil {
+			t.Fatalf("RevokeUserPersonalAccessTokens: %v", err)
+		}
+		if tokens, err := store.GetPersonalAccessTokens(ctx, userID); err != nil || len(tokens) != 0 {
+			t.Fatalf("GetPersonalAccessTokens after revoking all = %d tokens, %v; want none", len(tokens), err)
+		}
+		if tokens, err := store.GetPersonalAccessTokens(ctx, otherID); err != nil || len(tokens) != 1 {
+			t.Fatalf("GetPersonalAccessTokens of another user = %d tokens, %v; want 1", len(tokens), err)
+		}
+	})
+}
+
+func RunLoginAttemptStoreTests(t *testing.T, newStores LoginAttemptStoreFactory) {
+	t.Run("RecordAndList", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		recordAttempt(t, store, 0, types.LoginUserNotFound)
+		recordAttempt(t, store, userID, types.LoginInvalidPassword)
+		recordAttempt(t, store, userID, "")
+
+		attempts, err := store.GetLoginAttempts(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("GetLoginAttempts: %v", err)
+		}
+		if len(attempts) != 2 {
+			t.Fatalf("GetLoginAttempts returned %d attempts, want 2", len(attempts))
+		}
+		latest := attempts[0]
+		if !latest.Success || latest.IP != "127.0.0.1" || latest.UserAgent != "test" || latest.CreatedAt.IsZero() {
+			t.Fatalf("latest attempt is %+v, want the successful one", latest)
+		}
+		if attempts[1].Success || attempts[1].Reason != types.LoginInvalidPassword {
+			t.Fatalf("oldest attempt is %+v, want the failed one", attempts[1])
+		}
+
+		if attempts, err := store.GetLoginAttempts(ctx, userID, 1); err != nil || len(attempts) != 1 {
+			t.Fatalf("GetLoginAttempts with limit 1 = %d attempts, %v", len(attempts), err)
+		}
+	})
+
+	t.Run("FailuresResetOnSuccess", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if failures, last, err := store.GetLoginFailures(ctx, userID); err != nil || failures != 0 || last != nil {
+			t.Fatalf("GetLoginFailures without attempts = %d, %v, %v", failures, last, err)
+		}
+
+		recordAttempt(t, store, userID, types.LoginInvalidPassword)
+		recordAttempt(t, store, userID, "")
+		recordAttempt(t, store, userID, types.LoginInvalidPassword)
+		recordAttempt(t, store, userID, types.LoginAccountLocked)
+		recordAttempt(t, store, userID, types.LoginInvalidPassword)
+		recordAttempt(t, store, userID, types.LoginInvalidMFACode)
+
+		failures, last, err := store.GetLoginFailures(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetLoginFailures: %v", err)
+		}
+		if failures != 3 || last == nil {
+			t.Fatalf("GetLoginFailures = %d, %v; want 2 wrong passwords and a wrong code since the last success", failures, last)
+		}
+	})
+}
+
+func RunIdentityStoreTests(t *testing.T, newStores IdentityStoreFactory) {
+	t.Run("CreateGetAndTouch", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if _, err := store.GetUserIdentity(ctx, "https://idp.example.com", "abc"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetUserIdentity before linking = %v, want not found", err)
+		}
+
+		id, err := store.CreateUserIdentity(ctx, types.UserIdentity{
+			UserID:  userID,
+			Issuer:  "https://idp.example.com",
+			Subject: "abc",
+			Email:   "jane@example.com",
+		})
+		if err != nil {
+			t.Fatalf("CreateUserIdentity: %v", err)
+		}
+
+		identity, err := store.GetUserIdentity(ctx, "https://idp.example.com", "abc")
+		if err != nil {
+			t.Fatalf("GetUserIdentity: %v", err)
+		}
+		if identity.ID != id || identity.UserID != userID || identity.Email != "jane@example.com" || identity.CreatedAt.IsZero() || identity.LastLoginAt != nil {
+			t.Fatalf("GetUserIdentity returned %+v", identity)
+		}
+
+		// the subject alone does not identify a user across providers
+		if _, err := store.GetUserIdentity(ctx, "https://other.example.com", "abc"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetUserIdentity at another issuer = %v, want not found", err)
+		}
+
+		at := time.Now().Truncate(time.Second)
+		if err := store.TouchUserIdentity(ctx, id, at); err != nil {
+			t.Fatalf("TouchUserIdentity: %v", err)
+		}
+		identity, err = store.GetUserIdentity(ctx, "https://idp.example.com", "abc")
+		if err != nil {
+			t.Fatalf("GetUserIdentity: %v", err)
+		}
+		if identity.LastLoginAt == nil || !identity.LastLoginAt.Equal(at) {
+			t.Fatalf("LastLoginAt = %v, want %v", identity.LastLoginAt, at)
+		}
+	})
+
+	t.Run("LinkedOnce", func(t *testing.T) {
+		store, users := newStores(t)
+		jane := createUser(t, users, "jane")
+		john := createUser(t, users, "john")
+
+		identity := types.UserIdentity{UserID: jane, Issuer: "https://idp.example.com", Subject: "abc"}
+		if _, err := store.CreateUserIdentity(ctx, identity); err != nil {
+			t.Fatalf("CreateUserIdentity: %v", err)
+		}
+		identity.UserID = john
+		if _, err := store.CreateUserIdentity(ctx, identity); !errors.Is(err, types.ErrConflict) {
+			t.Fatalf("linking an identity twice = %v, want a conflict", err)
+		}
+	})
+
+	t.Run("PurgedWithUser", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if _, err := store.CreateUserIdentity(ctx, types.UserIdentity{UserID: userID, Issuer: "https://idp.example.com", Subject: "abc"}); err != nil {
+			t.Fatalf("CreateUserIdentity: %v", err)
+		}
+		purgeUser(t, users, userID)
+		if _, err := store.GetUserIdentity(ctx, "https://idp.example.com", "abc"); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("GetUserIdentity after purge = %v, want not found", err)
+		}
+	})
+}
+
+func RunMFAStoreTests(t *testing.T, newStores MFAStoreFactory) {
+	t.Run("EnrollEnableDisable", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		// confirming needs a secret awaiting confirmation
+		if err := store.EnableTOTP(ctx, userID, 1, time.Now(), nil); !errors.Is(err, types.ErrConflict) {
+			t.Fatalf("EnableTOTP before enrolling = %v, want a conflict", err)
+		}
+
+		if err := store.SetTOTPSecret(ctx, userID, "sealed"); err != nil {
+			t.Fatalf("SetTOTPSecret: %v", err)
+		}
+		user, err := users.GetUserByID(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if user.TOTPSecret != "sealed" || user.TOTPEnabledAt != nil {
+			t.Fatalf("after enrolling TOTPSecret = %q, TOTPEnabledAt = %v", user.TOTPSecret, user.TOTPEnabledAt)
+		}
+
+		at := time.Now().Truncate(time.Second)
+		if err := store.EnableTOTP(ctx, userID, 100, at, []string{"a", "b"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		user, err = users.GetUserByID(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if user.TOTPEnabledAt == nil || !user.TOTPEnabledAt.Equal(at) || user.TOTPLastStep != 100 {
+			t.Fatalf("after enabling TOTPEnabledAt = %v, TOTPLastStep = %d", user.TOTPEnabledAt, user.TOTPLastStep)
+		}
+		if count, err := store.CountRecoveryCodes(ctx, userID); err != nil || count != 2 {
+			t.Fatalf("CountRecoveryCodes = %d, %v; want 2", count, err)
+		}
+		if err := store.EnableTOTP(ctx, userID, 101, at, nil); !errors.Is(err, types.ErrConflict) {
+			t.Fatalf("EnableTOTP twice = %v, want a conflict", err)
+		}
+
+		if err := store.DisableTOTP(ctx, userID); err != nil {
+			t.Fatalf("DisableTOTP: %v", err)
+		}
+		user, err = users.GetUserByID(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if user.TOTPSecret != "" || user.TOTPEnabledAt != nil {
+			t.Fatalf("after disabling TOTPSecret = %q, TOTPEnabledAt = %v", user.TOTPSecret, user.TOTPEnabledAt)
+		}
+		if count, err := store.CountRecoveryCodes(ctx, userID); err != nil || count != 0 {
+			t.Fatalf("CountRecoveryCodes after disabling = %d, %v; want 0", count, err)
+		}
+	})
+
+	t.Run("StepsUsedOnce", func(t *testing.T) {
+		store, users := newStores(t)
+		userID := createUser(t, users, "jane")
+
+		if err := store.SetTOTPSecret(ctx, userID, "sealed"); err != nil {
+			t.Fatalf("SetTOTPSecret: %v", err)
+		}
+		if err := store.EnableTOTP(ctx, userID, 100, time.Now(), nil); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+
+		// the step confirming enrollment counts as used
+		for _, tc := range []struct {
+			step int64
+			want bool
+		}{{100, false}, {101, true}, {101, false}, {99, false}, {103, true}} {
+			if used, err := store.UseTOTPStep(ctx, userID, tc.step); err != nil || used != tc.want {
+				t.Fatalf("UseTOTPStep(%d) = %v, %v; want %v", tc.step, used, err, tc.want)
+			}
+		}
+	})
+
+	t.Run("RecoveryCodesUsedOnce", func(t *testing.T) {
+		store, users := newStores(t)
+		jane := createUser(t, users, "jane")
+		john := createUser(t, users, "john")
+
+		if err := store.ReplaceRecoveryCodes(ctx, jane, []string{"a", "b"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if used, err := store.UseRecoveryCode(ctx, john, "a", time.Now()); err != nil || used {
+			t.Fatalf("UseRecoveryCode of another user = %v, %v; want unused", used, err)
+		}
+		if used, err := store.UseRecoveryCode(ctx, jane, "a", time.Now()); err != nil || !used {
+			t.Fatalf("UseRecoveryCode = %v, %v; want used", used, err)
+		}
+		if used, err := store.UseRecoveryCode(ctx, jane, "a", time.Now()); err != nil || used {
+			t.Fatalf("UseRecoveryCode twice = %v, %v; want unused", used, err)
+		}
+		if count, err := store.CountRecoveryCodes(ctx, jane); err != nil || count != 1 {
+			t.Fatalf("CountRecoveryCodes = %d, %v; want 1", count, err)
+		}
+
+		// replacing drops the old codes, used or not
+		if err := store.ReplaceRecoveryCodes(ctx, jane, []string{"c"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if used, err := store.UseRecoveryCode(ctx, jane, "b", time.Now()); err != nil || used {
+			t.Fatalf("UseRecoveryCode of a replaced code = %v, %v; want unused", used, err)
+		}
+		if count, err := store.CountRecoveryCodes(ctx, jane); err != nil || count != 1 {
+			t.Fatalf("CountRecoveryCodes after replacing = %d, %v; want 1", count, err)
+		}
+
+		purgeUser(t, users, jane)
+		if count, err := store.CountRecoveryCodes(ctx, jane); err != nil || count != 0 {
+			t.Fatalf("CountRecoveryCodes after purge = %d, %v; want 0", count, err)
+		}
+	})
+}
+
+func recordAttempt(t *testing.T, store types.LoginAttemptStore, userID int, reason string) {
+	t.Helper()
+	err := store.RecordLoginAttempt(ctx, types.LoginAttempt{
+		UserID:     userID,
+		Identifier: "jane",
+		IP:         "127.0.0.1",
+		UserAgent:  "test",
+		Success:    reason == "",
+		Reason:     reason,
+	})
+	if err != nil {
+		t.Fatalf("RecordLoginAttempt: %v", err)
+	}
+}
+
+// assertConflict fails unless err is a conflict on field.
+func assertConflict(t *testing.T, err error, field string) {
+	t.Helper()
+	var typed *types.Error
+	if !errors.As(err, &typed) || !errors.Is(err, types.ErrConflict) {
+		t.Fatalf("error = %v, want a conflict", err)
+	}
+	if len(typed.Details) != 1 || typed.Details[0].Field != field {
+		t.Fatalf("conflict details = %+v, want field %s", typed.Details, field)
+	}
+}
+
+func createUser(t *testing.T, store types.UserStore, username string) int {
+	t.Helper()
+	id, err := store.CreateUser(ctx, types.User{
+		FirstName: "Test",
+		LastName:  "User",
+		UserName:  username,
+		Email:     username + "@example.com",
+		Password:  "hash",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return id
+}
+
+// purgeUser deletes the user and purges them straight away.
+func purgeUser(t *testing.T, store types.UserStore, id int) {
+	t.Helper()
+	if err := store.DeleteUser(ctx, id, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if purged, err := store.PurgeUser(ctx, id, time.Now()); err != nil || !purged {
+		t.Fatalf("PurgeUser = %v, %v; want purged", purged, err)
+	}
+}
+
+// createTodos creates one todo per title.
+func createTodos(t *testing.T, store types.TodoStore, userID int, titles ...string) {
+	t.Helper()
+	for _, title := range titles {
+		err := store.CreateTodo(ctx, types.Todo{Title: title, Description: title + " description", UserID: userID})
+		if err != nil {
+			t.Fatalf("CreateTodo(%s): %v", title, err)
+		}
+	}
+}
+
+func listTodos(t *testing.T, store types.TodoStore, userID int, query types.TodoQuery) *types.TodoPage {
+	t.Helper()
+	page, err := store.GetTodos(ctx, userID, query)
+	if err != nil {
+		t.Fatalf("GetTodos(%+v): %v", query, err)
+	}
+	return page
+}
+
+func titles(todos []*types.Todo) []string {
+	out := make([]string, 0, len(todos))
+	for _, todo := range todos {
+		out = append(out, todo.Title)
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+package todo
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// MemoryStore is a TodoStore kept entirely in memory, for local runs and tests
+// without a database server. It mirrors the behaviour of Store.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	nextID int
+	todos  map[int]types.Todo
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1, todos: make(map[int]types.Todo)}
+}
+
+func (s *MemoryStore) CreateTodo(_ context.Context, todo types.Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	todo.ID = s.nextID
+	todo.Status = "pending"
+	todo.CreatedAt = now
+	todo.UpdatedAt = now
+	s.todos[todo.ID] = todo
+	s.nextID++
+	return nil
+}
+
+func (s *MemoryStore) GetTodos(_ context.Context, id int, query types.TodoQuery) (*types.TodoPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	search := strings.ToLower(query.Search)
+	matches := make([]*types.Todo, 0)
+	for _, todo := range s.todos {
+		if todo.UserID != id {
+			continue
+		}
+		if query.Status != "" && todo.Status != query.Status {
+			continue
+		}
+		if query.CreatedAfter != nil && todo.CreatedAt.Before(*query.CreatedAfter) {
+			continue
+		}
+		if query.CreatedBefore != nil && !todo.CreatedAt.Before(*query.CreatedBefore) {
+			continue
+		}
+		if search != "" &&
+			!strings.Contains(strings.ToLower(todo.Title), search) &&
+			!strings.Contains(strings.ToLower(todo.Description), search) {
+			continue
+		}
+		todo := todo
+		matches = append(matches, &todo)
+	}
+	total := len(matches)
+
+	column := query.SortBy
+	if _, ok := sortColumns[column]; !ok {
+		column = "created_at"
+	}
+	less := func(a, b *types.Todo) bool {
+		if c := compareColumn(column, a, b); c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if query.SortDesc {
+			return less(matches[j], matches[i])
+		}
+		return less(matches[i], matches[j])
+	})
+
+	if query.Cursor != nil {
+		after := make([]*types.Todo, 0, len(matches))
+		for _, todo := range matches {
+			c, err := compareCursor(column, todo, query.Cursor)
+			if err != nil {
+				return nil, types.Validation("invalid_cursor", "invalid cursor")
+			}
+			if (!query.SortDesc && c > 0) || (query.SortDesc && c < 0) {
+				after = append(after, todo)
+			}
+		}
+		matches = after
+	} else if query.Offset > 0 {
+		if query.Offset >= len(matches) {
+			matches = matches[:0]
+		} else {
+			matches = matches[query.Offset:]
+		}
+	}
+
+	page := &types.TodoPage{Todos: matches, Total: total}
+	if len(matches) > query.Limit {
+		page.Todos = matches[:query.Limit]
+		page.NextCursor = cursorFor(column, page.Todos[len(page.Todos)-1])
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) GetTodoByID(_ context.Context, id int) (*types.Todo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	todo, ok := s.todos[id]
+	if !ok {
+		return nil, types.NotFound("todo_not_found", "todo not found")
+	}
+	return &todo, nil
+}
+
+func (s *MemoryStore) UpdateTodo(_ context.Context, id int, payload types.TodoUpdatePayload) (*types.Todo, error) {
+	if payload.Title == nil && payload.Description == nil && payload.Status == nil {
+		return nil, types.Validation("validation_failed", "nothing to update")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo, ok := s.todos[id]
+	if !ok {
+		return nil, types.NotFound("todo_not_found", "todo not found")
+	}
+	if payload.Title != nil {
+		todo.Title = *payload.Title
+	}
+	if payload.Description != nil {
+		todo.Description = *payload.Description
+	}
+	if payload.Status != nil {
+		todo.Status = *payload.Status
+	}
+	todo.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.todos[id] = todo
+	return &todo, nil
+}
+
+func (s *MemoryStore) DeleteTodo(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.todos, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserTodos(_ context.Context, userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int
+	for id, todo := range s.todos {
+		if todo.UserID == userID {
+			delete(s.todos, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// compareColumn orders two todos by column the way the SQL stores do.
+func compareColumn(column string, a, b *types.Todo) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	default:
+		return a.ID - b.ID
+	}
+}
+
+// compareCursor orders todo relative to the position described by cursor.
+func compareCursor(column string, todo *types.Todo, cursor *types.TodoCursor) (int, error) {
+	if column == "id" {
+		return todo.ID - cursor.ID, nil
+	}
+
+	var c int
+	switch column {
+	case "title":
+		c = strings.Compare(todo.Title, cursor.Value)
+	case "status":
+		c = strings.Compare(todo.Status, cursor.Value)
+	default:
+		value, err := cursorValue(column, cursor.Value)
+		if err != nil {
+			return 0, err
+		}
+		t := todo.CreatedAt
+		if column == "updated_at" {
+			t = todo.UpdatedAt
+		}
+		c = t.Compare(value.(time.Time))
+	}
+	if c != 0 {
+		return c, nil
+	}
+	return todo.ID - cursor.ID, nil
+}
@@ -1,11 +1,16 @@
 package todo
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Waris-Shaik/todo/metrics"
 	"github.com/Waris-Shaik/todo/services/auth"
 	"github.com/Waris-Shaik/todo/types"
 	"github.com/Waris-Shaik/todo/utils"
@@ -13,21 +18,23 @@ import (
 )
 
 type Handler struct {
-	store     types.TodoStore
-	userstore types.UserStore
+	store        types.TodoStore
+	userstore    types.UserStore
+	revocations  types.TokenRevocationStore
+	accessTokens types.PersonalAccessTokenStore
 }
 
-func NewHandler(store types.TodoStore, userstore types.UserStore) *Handler {
-	return &Handler{store: store, userstore: userstore}
+func NewHandler(store types.TodoStore, userstore types.UserStore, revocations types.TokenRevocationStore, accessTokens types.PersonalAccessTokenStore) *Handler {
+	return &Handler{store: store, userstore: userstore, revocations: revocations, accessTokens: accessTokens}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 
-	router.HandleFunc("/todos", auth.WithJWTAuth(h.handleGetTodos, h.userstore)).Methods(http.MethodGet)
-	router.HandleFunc("/todos/new", auth.WithJWTAuth(h.handleCreateTodo, h.userstore)).Methods(http.MethodPost)
-	router.HandleFunc("/todos/{id}", auth.WithJWTAuth(h.handleGetTodo, h.userstore)).Methods(http.MethodGet)
-	router.HandleFunc("/todos/update/{id}", auth.WithJWTAuth(h.handleUpdateTodo, h.userstore)).Methods(http.MethodPatch)
-	router.Handle("/todos/delete/{id}", auth.WithJWTAuth(h.handleDeleteTodo, h.userstore)).Methods(http.MethodDelete)
+	router.HandleFunc("/todos", auth.WithJWTAuth(h.handleGetTodos, h.userstore, h.revocations, h.accessTokens, auth.ScopeTodosRead)).Methods(http.MethodGet).Name("todos.list")
+	router.HandleFunc("/todos/new", auth.WithJWTAuth(h.handleCreateTodo, h.userstore, h.revocations, h.accessTokens, auth.ScopeTodosWrite)).Methods(http.MethodPost).Name("todos.create")
+	router.HandleFunc("/todos/{id}", auth.WithJWTAuth(h.handleGetTodo, h.userstore, h.revocations, h.accessTokens, auth.ScopeTodosRead)).Methods(http.MethodGet).Name("todos.get")
+	router.HandleFunc("/todos/update/{id}", auth.WithJWTAuth(h.handleUpdateTodo, h.userstore, h.revocations, h.accessTokens, auth.ScopeTodosWrite)).Methods(http.MethodPatch).Name("todos.update")
+	router.Handle("/todos/delete/{id}", auth.WithJWTAuth(h.handleDeleteTodo, h.userstore, h.revocations, h.accessTokens, auth.ScopeTodosWrite)).Methods(http.MethodDelete).Name("todos.delete")
 
 }
 
@@ -38,30 +45,31 @@ func (h *Handler) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
 	// get the JSON payloaf from req.body and parse it
 	var payload types.TodoPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
-		log.Println("Error parsing PAYLOAD:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// validate the payload
-	if err := utils.ValidateTodoPayload(&payload); err != nil {
-		log.Println("Error validating payload", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// create the todo
-	err := h.store.CreateTodo(types.Todo{
+	err := h.store.CreateTodo(r.Context(), types.Todo{
 		Title:       payload.Title,
 		Description: payload.Description,
 		UserID:      userID,
 	})
 
 	if err != nil {
-		log.Println("Error while creating todo", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.ErrorContext(r.Context(), "creating todo failed", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
+	metrics.TodosCreated.Inc()
 
 	// return the response
 	response := struct {
@@ -77,20 +85,44 @@ func (h *Handler) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handleGetTodos(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
-	todos, err := h.store.GetTodos(userID)
+
+	// parse filters, sorting and pagination from the query string
+	query, err := parseTodoQuery(r)
+	if err != nil {
+		slog.InfoContext(r.Context(), "invalid query parameters", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	page, err := h.store.GetTodos(r.Context(), userID, query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "listing todos failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	nextCursor, err := encodeCursor(page.NextCursor)
 	if err != nil {
-		log.Println("Error while retreiving todos", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.ErrorContext(r.Context(), "encoding cursor failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
 		return
 	}
 
 	// retrun thr response
 	response := struct {
-		Success bool          `json:"success"`
-		Todos   []*types.Todo `json:"todos"`
+		Success    bool          `json:"success"`
+		Todos      []*types.Todo `json:"todos"`
+		Total      int           `json:"total"`
+		Limit      int           `json:"limit"`
+		Offset     int           `json:"offset"`
+		NextCursor string        `json:"next_cursor,omitempty"`
 	}{
-		Success: true,
-		Todos:   todos,
+		Success:    true,
+		Todos:      page.Todos,
+		Total:      page.Total,
+		Limit:      query.Limit,
+		Offset:     query.Offset,
+		NextCursor: nextCursor,
 	}
 	utils.WriteJSON(w, http.StatusOK, response)
 }
@@ -98,8 +130,8 @@ func (h *Handler) handleGetTodos(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleGetTodo(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 	if userID == -1 {
-		log.Println("Unauthorized access: Invalid user ID")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Forbidden("login_required", "unauthorized access"))
 		return
 	}
 
@@ -108,30 +140,30 @@ func (h *Handler) handleGetTodo(w http.ResponseWriter, r *http.Request) {
 	idStr := vars["id"]
 
 	if idStr == "" {
-		log.Println("TodoID is required")
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("todoID is required"))
+		slog.InfoContext(r.Context(), "todo ID missing")
+		utils.WriteError(w, types.Validation("todo_id_required", "todoID is required"))
 		return
 	}
 
 	todoID, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println("Failed to convert todoID:", err)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to convert str to int"))
+		slog.InfoContext(r.Context(), "invalid todo ID", "err", err)
+		utils.WriteError(w, types.Validation("invalid_todo_id", "todoID must be an integer"))
 		return
 	}
 
 	// retreive todo from store
-	todo, err := h.store.GetTodoByID(todoID)
+	todo, err := h.store.GetTodoByID(r.Context(), todoID)
 	if err != nil {
-		log.Println("invalid id todo not found", err)
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("todo not found"))
+		slog.InfoContext(r.Context(), "loading todo failed", "todo_id", todoID, "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// Ensure that the task belongs to the current user (if needed)
 	if userID != todo.UserID {
-		log.Println("Unauthorized access: Task does not belong to the user")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.WarnContext(r.Context(), "todo belongs to another user", "todo_id", todoID)
+		utils.WriteError(w, types.Forbidden("todo_forbidden", "unauthorized access"))
 		return
 	}
 	// return the success response
@@ -150,8 +182,8 @@ func (h *Handler) handleGetTodo(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 	if userID == -1 {
-		log.Println("Unauthorized access: Invalid user ID")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Forbidden("login_required", "unauthorized access"))
 		return
 	}
 
@@ -160,46 +192,70 @@ func (h *Handler) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 	idStr := vars["id"]
 
 	if idStr == "" {
-		log.Println("TodoID is required")
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("todoID is required"))
+		slog.InfoContext(r.Context(), "todo ID missing")
+		utils.WriteError(w, types.Validation("todo_id_required", "todoID is required"))
 		return
 	}
 
 	todoID, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println("Failed to convert todoID:", err)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to convert str to int"))
+		slog.InfoContext(r.Context(), "invalid todo ID", "err", err)
+		utils.WriteError(w, types.Validation("invalid_todo_id", "todoID must be an integer"))
 		return
 	}
 
 	// retreive todo from store
-	todo, err := h.store.GetTodoByID(todoID)
+	todo, err := h.store.GetTodoByID(r.Context(), todoID)
 	if err != nil {
-		log.Println("invalid id todo not found", err)
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("todo not found"))
+		slog.InfoContext(r.Context(), "loading todo failed", "todo_id", todoID, "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// Ensure that the task belongs to the current user (if needed)
 	if userID != todo.UserID {
-		log.Println("Unauthorized access: Task does not belong to the user")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.WarnContext(r.Context(), "todo belongs to another user", "todo_id", todoID)
+		utils.WriteError(w, types.Forbidden("todo_forbidden", "unauthorized access"))
 		return
 	}
-	// Update the todo status
-	if err := h.store.UpdateTodo(todo.ID); err != nil {
-		log.Println("Error updating task:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+
+	// get the JSON payload from req.body and parse it
+	var payload types.TodoUpdatePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// validate the payload
+	if payload.Title == nil && payload.Description == nil && payload.Status == nil {
+		slog.InfoContext(r.Context(), "empty update payload")
+		utils.WriteError(w, types.Validation("validation_failed", "at least one of title, description or status is required"))
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// apply the update
+	updatedTodo, err := h.store.UpdateTodo(r.Context(), todo.ID, payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "updating todo failed", "todo_id", todoID, "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// Return success response
 	response := struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
+		Success bool       `json:"success"`
+		Message string     `json:"message"`
+		Todo    types.Todo `json:"todo"`
 	}{
 		Success: true,
 		Message: "todo updated successfully",
+		Todo:    *updatedTodo,
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
@@ -208,8 +264,8 @@ func (h *Handler) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 	if userID == -1 {
-		log.Println("Unauthorized access: Invalid user ID")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Forbidden("login_required", "unauthorized access"))
 		return
 	}
 
@@ -218,38 +274,38 @@ func (h *Handler) handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 	idStr := vars["id"]
 
 	if idStr == "" {
-		log.Println("TodoID is required")
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("todoID is required"))
+		slog.InfoContext(r.Context(), "todo ID missing")
+		utils.WriteError(w, types.Validation("todo_id_required", "todoID is required"))
 		return
 	}
 
 	todoID, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println("Failed to convert todoID:", err)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to convert str to int"))
+		slog.InfoContext(r.Context(), "invalid todo ID", "err", err)
+		utils.WriteError(w, types.Validation("invalid_todo_id", "todoID must be an integer"))
 		return
 	}
 
 	// retreive todo from store
-	todo, err := h.store.GetTodoByID(todoID)
+	todo, err := h.store.GetTodoByID(r.Context(), todoID)
 	if err != nil {
-		log.Println("invalid id todo not found", err)
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("todo not found"))
+		slog.InfoContext(r.Context(), "loading todo failed", "todo_id", todoID, "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// Ensure that the task belongs to the current user (if needed)
 	if userID != todo.UserID {
-		log.Println("Unauthorized access: Task does not belong to the user")
-		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("unauthorized access"))
+		slog.WarnContext(r.Context(), "todo belongs to another user", "todo_id", todoID)
+		utils.WriteError(w, types.Forbidden("todo_forbidden", "unauthorized access"))
 		return
 	}
 
 	// delete the todo
-	err = h.store.DeleteTodo(todo.ID)
+	err = h.store.DeleteTodo(r.Context(), todo.ID)
 	if err != nil {
-		log.Println("Error while deleting todo:", err)
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		slog.ErrorContext(r.Context(), "deleting todo failed", "todo_id", todoID, "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
@@ -265,3 +321,111 @@ func (h *Handler) handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, response)
 
 }
+
+const (
+	defaultTodosLimit = 50
+	maxTodosLimit     = 200
+)
+
+// parseTodoQuery reads the list filters from the request query string:
+// status, created_after, created_before (RFC 3339), q, sort (a field, prefixed
+// with "-" for descending), limit, offset and cursor.
+func parseTodoQuery(r *http.Request) (types.TodoQuery, error) {
+	params := r.URL.Query()
+	query := types.TodoQuery{
+		Status: params.Get("status"),
+		Search: strings.TrimSpace(params.Get("q")),
+		Limit:  defaultTodosLimit,
+		SortBy: "created_at",
+	}
+
+	if query.Status != "" && query.Status != "pending" && query.Status != "completed" {
+		return query, types.Validation("invalid_query", "status must be pending or completed")
+	}
+
+	for _, key := range []string{"created_after", "created_before"} {
+		value := params.Get(key)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, types.Validation("invalid_query", fmt.Sprintf("%s must be an RFC 3339 timestamp", key))
+		}
+		if key == "created_after" {
+			query.CreatedAfter = &t
+		} else {
+			query.CreatedBefore = &t
+		}
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		query.SortDesc = strings.HasPrefix(sort, "-")
+		query.SortBy = strings.TrimPrefix(sort, "-")
+		switch query.SortBy {
+		case "id", "title", "status", "created_at", "updated_at":
+		default:
+			return query, types.Validation("invalid_query", fmt.Sprintf("cannot sort by %q", query.SortBy))
+		}
+	}
+	if order := params.Get("order"); order != "" {
+		switch strings.ToLower(order) {
+		case "asc":
+			query.SortDesc = false
+		case "desc":
+			query.SortDesc = true
+		default:
+			return query, types.Validation("invalid_query", "order must be asc or desc")
+		}
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTodosLimit {
+			return query, types.Validation("invalid_query", fmt.Sprintf("limit must be between 1 and %d", maxTodosLimit))
+		}
+		query.Limit = limit
+	}
+
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return query, types.Validation("invalid_query", "offset must be a non-negative integer")
+		}
+		query.Offset = offset
+	}
+
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return query, types.Validation("invalid_cursor", "invalid cursor")
+		}
+		query.Cursor = cursor
+		query.Offset = 0
+	}
+
+	return query, nil
+}
+
+func encodeCursor(cursor *types.TodoCursor) (string, error) {
+	if cursor == nil {
+		return "", nil
+	}
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(value string) (*types.TodoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := new(types.TodoCursor)
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
@@ -1,10 +1,14 @@
 package todo
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/Waris-Shaik/todo/db"
 	"github.com/Waris-Shaik/todo/types"
 )
 
@@ -16,33 +20,154 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) CreateTodo(todo types.Todo) error {
-	_, err := s.db.Exec("INSERT INTO todo (title, description, userID) VALUES (?,?, ?)", todo.Title, todo.Description, todo.UserID)
+func (s *Store) CreateTodo(ctx context.Context, todo types.Todo) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO todo (title, description, userID) VALUES (?,?, ?)", todo.Title, todo.Description, todo.UserID)
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return db.Error(ctx, err)
 	}
 
 	return nil
 }
 
-func (s *Store) GetTodos(id int) ([]*types.Todo, error) {
-	rows, err := s.db.Query("SELECT * FROM todo WHERE userID IN(?)", id)
+// sortColumns whitelists the columns todos can be ordered by.
+var sortColumns = map[string]string{
+	"id":         "id",
+	"title":      "title",
+	"status":     "status",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+func (s *Store) GetTodos(ctx context.Context, id int, query types.TodoQuery) (*types.TodoPage, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	where := []string{"userID = ?"}
+	args := []any{id}
+
+	if query.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, query.Status)
+	}
+	if query.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, query.CreatedAfter.UTC())
+	}
+	if query.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, query.CreatedBefore.UTC())
+	}
+	if query.Search != "" {
+		pattern := "%" + escapeLike(query.Search) + "%"
+		where = append(where, "(title LIKE ? ESCAPE '!' OR description LIKE ? ESCAPE '!')")
+		args = append(args, pattern, pattern)
+	}
+
+	// count every match before pagination is applied
+	var total int
+	countQuery := "SELECT COUNT(*) FROM todo WHERE " + strings.Join(where, " AND ")
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+
+	column, ok := sortColumns[query.SortBy]
+	if !ok {
+		column = "created_at"
+	}
+	direction, comparison := "ASC", ">"
+	if query.SortDesc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if query.Cursor != nil {
+		if column == "id" {
+			where = append(where, fmt.Sprintf("id %s ?", comparison))
+			args = append(args, query.Cursor.ID)
+		} else {
+			value, err := cursorValue(column, query.Cursor.Value)
+			if err != nil {
+				slog.InfoContext(ctx, "invalid cursor", "err", err)
+				return nil, types.Validation("invalid_cursor", "invalid cursor")
+			}
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison))
+			args = append(args, value, value, query.Cursor.ID)
+		}
+	}
+
+	// fetch one extra row to know whether another page exists
+	listQuery := fmt.Sprintf(
+		"SELECT * FROM todo WHERE %s ORDER BY %s %s, id %s LIMIT ?",
+		strings.Join(where, " AND "), column, direction, direction,
+	)
+	args = append(args, query.Limit+1)
+	if query.Cursor == nil && query.Offset > 0 {
+		listQuery += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, listQuery, args...)
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return nil, err
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
 	}
+	defer rows.Close()
 
 	todos := make([]*types.Todo, 0)
 	for rows.Next() {
 		todo, err := scanRowsIntoTodo(rows)
 		if err != nil {
-			log.Println("Error in rows.Next():", err)
-			return nil, err
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
 		}
 		todos = append(todos, todo)
 	}
-	return todos, nil
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+
+	page := &types.TodoPage{Todos: todos, Total: total}
+	if len(todos) > query.Limit {
+		page.Todos = todos[:query.Limit]
+		page.NextCursor = cursorFor(column, page.Todos[len(page.Todos)-1])
+	}
+	return page, nil
+}
+
+// cursorFor builds the keyset cursor that continues after todo.
+func cursorFor(column string, todo *types.Todo) *types.TodoCursor {
+	cursor := &types.TodoCursor{ID: todo.ID}
+	switch column {
+	case "title":
+		cursor.Value = todo.Title
+	case "status":
+		cursor.Value = todo.Status
+	case "created_at":
+		cursor.Value = todo.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = todo.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// cursorValue converts the string form of a cursor back into a query argument for column.
+func cursorValue(column, value string) (any, error) {
+	switch column {
+	case "created_at", "updated_at":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t.UTC(), err
+	default:
+		return value, nil
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
 }
 
 func scanRowsIntoTodo(rows *sql.Rows) (*types.Todo, error) {
@@ -59,71 +184,131 @@ func scanRowsIntoTodo(rows *sql.Rows) (*types.Todo, error) {
 	)
 
 	if err != nil {
-		log.Println("Error in scanRowsIntoTodo:", err)
 		return nil, err
 	}
 	return todo, nil
 }
 
-func (s *Store) GetTodoByID(id int) (*types.Todo, error) {
-	rows, err := s.db.Query("SELECT * FROM todo WHERE id = ?", id)
+func (s *Store) GetTodoByID(ctx context.Context, id int) (*types.Todo, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM todo WHERE id = ?", id)
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return nil, err
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
 	}
+	defer rows.Close()
 
 	todo := new(types.Todo)
 	for rows.Next() {
 		todo, err = scanRowsIntoTodo(rows)
 		if err != nil {
-			log.Println("Error in rows.Next()", err)
-			return nil, err
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
 
 	if todo.ID == 0 {
-		log.Println("todo not found:", todo)
-		return nil, fmt.Errorf("todo not found")
+		return nil, types.NotFound("todo_not_found", "todo not found")
 	}
 
 	return todo, nil
 }
 
-func (s *Store) UpdateTodo(id int) error {
+func (s *Store) UpdateTodo(ctx context.Context, id int, payload types.TodoUpdatePayload) (*types.Todo, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	setClauses := make([]string, 0, 3)
+	args := make([]any, 0, 4)
+
+	if payload.Title != nil {
+		setClauses = append(setClauses, "title = ?")
+		args = append(args, *payload.Title)
+	}
+	if payload.Description != nil {
+		setClauses = append(setClauses, "description = ?")
+		args = append(args, *payload.Description)
+	}
+	if payload.Status != nil {
+		setClauses = append(setClauses, "status = ?")
+		args = append(args, *payload.Status)
+	}
+	if len(setClauses) == 0 {
+		return nil, types.Validation("validation_failed", "nothing to update")
+	}
+	args = append(args, id)
+
+	// apply the update and read back the row in a single transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE todo SET %s WHERE id = ?", strings.Join(setClauses, ", "))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		slog.ErrorContext(ctx, "updating todo failed", "todo_id", id, "err", err)
+		return nil, db.Error(ctx, err)
+	}
 
-	currentStatus, err := getCurrentTodoStatus(id, s.db)
+	todo := new(types.Todo)
+	err = tx.QueryRowContext(ctx, "SELECT * FROM todo WHERE id = ?", id).Scan(
+		&todo.ID,
+		&todo.Title,
+		&todo.Description,
+		&todo.Status,
+		&todo.UserID,
+		&todo.CreatedAt,
+		&todo.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, types.NotFound("todo_not_found", "todo not found")
+	}
 	if err != nil {
-		log.Println("Error getting current task status", err)
-		return fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "reading updated todo failed", "todo_id", id, "err", err)
+		return nil, db.Error(ctx, err)
 	}
-	newStatus := "pending"
-	if currentStatus == "pending" {
-		newStatus = "completed"
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return nil, db.Error(ctx, err)
 	}
 
-	_, err = s.db.Exec("UPDATE todo SET status = ? WHERE id = ?", newStatus, id)
+	return todo, nil
+}
+
+func (s *Store) DeleteTodo(ctx context.Context, id int) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
 
+	_, err := s.db.ExecContext(ctx, "DELETE FROM todo WHERE id = ?", id)
 	if err != nil {
-		log.Println("Error updating task status:", err)
-		return fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
 	}
 	return nil
 }
 
-func getCurrentTodoStatus(id int, db *sql.DB) (string, error) {
-	var status string
-	err := db.QueryRow("SELECT status FROM todo WHERE id = ?", id).Scan(&status)
+func (s *Store) DeleteUserTodos(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM todo WHERE userID = ?", userID)
 	if err != nil {
-		log.Println("Error getting current task status from database:", err)
-		return "", err
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return 0, db.Error(ctx, err)
 	}
-	return status, nil
-}
-func (s *Store) DeleteTodo(id int) error {
-	_, err := s.db.Exec("DELETE FROM todo WHERE id = ?", id)
+	affected, err := result.RowsAffected()
 	if err != nil {
-		log.Println("Error in EXEC:", err)
-		return fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return 0, db.Error(ctx, err)
 	}
-	return nil
+	return int(affected), nil
 }
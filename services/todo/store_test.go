@@ -0,0 +1,31 @@
+package todo_test
+
+import (
+	"testing"
+
+	"github.com/Waris-Shaik/todo/services/storetest"
+	"github.com/Waris-Shaik/todo/services/todo"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.RunTodoStoreTests(t, func(t *testing.T) (types.TodoStore, types.UserStore) {
+		return todo.NewMemoryStore(), user.NewMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.RunTodoStoreTests(t, func(t *testing.T) (types.TodoStore, types.UserStore) {
+		db := storetest.SQLite(t)
+		return todo.NewStore(db), user.NewStore(db)
+	})
+}
+
+func TestMySQLStore(t *testing.T) {
+	storetest.SkipWithoutMySQL(t)
+	storetest.RunTodoStoreTests(t, func(t *testing.T) (types.TodoStore, types.UserStore) {
+		db := storetest.MySQL(t)
+		return todo.NewStore(db), user.NewStore(db)
+	})
+}
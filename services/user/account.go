@@ -0,0 +1,211 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+type deleteAccountPayload struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// handleDeleteAccount schedules the current user's account for deletion once
+// they confirm their password, and signs them out everywhere. Logging in
+// during the grace period cancels it.
+func (h *Handler) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	var payload deleteAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// shares its budget with password changes, both take the current password
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("password:user:%d", userID)) {
+		return
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "delete account: loading user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if !auth.MatchPassword(&user.Password, &payload.Password) {
+		slog.InfoContext(r.Context(), "delete account: wrong password")
+		utils.WriteError(w, types.Unauthorized("invalid_password", "invalid password"))
+		return
+	}
+
+	now := time.Now()
+	if err := h.store.DeleteUser(r.Context(), userID, now); err != nil {
+		slog.ErrorContext(r.Context(), "delete account: marking user deleted failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	if err := auth.RevokeAllSessions(r.Context(), h.revocations, h.refreshStore, userID); err != nil {
+		slog.ErrorContext(r.Context(), "delete account: revoking sessions failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	clearSessionCookies(w)
+
+	purgeAt := h.deletion.PurgeAt(now).UTC().Truncate(time.Second)
+	slog.InfoContext(r.Context(), "account scheduled for deletion", "purge_at", purgeAt)
+
+	response := struct {
+		Success bool      `json:"success"`
+		Message string    `json:"message"`
+		PurgeAt time.Time `json:"purge_at"`
+	}{
+		Success: true,
+		Message: fmt.Sprintf("your account will be deleted on %s, log in before then to keep it", purgeAt.Format("2 January 2006")),
+		PurgeAt: purgeAt,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// maxExportEvents caps the security events included in an export.
+const maxExportEvents = 1000
+
+// accountExport is everything stored about a user.
+type accountExport struct {
+	ExportedAt     time.Time             `json:"exported_at"`
+	Profile        *types.User           `json:"profile"`
+	Todos          []*types.Todo         `json:"todos"`
+	SecurityEvents []*types.LoginAttempt `json:"security_events"`
+}
+
+// handleExport returns a download of the current user's profile, todos and
+// security events, as a JSON document or, with ?format=zip, a ZIP archive
+// with one JSON file each.
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		utils.WriteError(w, types.Validation("invalid_query", "format must be json or zip"))
+		return
+	}
+
+	export, err := h.collectExport(r, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "export: collecting data failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("todo-export-%d-%s", userID, export.ExportedAt.Format("20060102"))
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		utils.WriteJSON(w, http.StatusOK, export)
+		return
+	}
+
+	archive, err := zipExport(export)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "export: building archive failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+func (h *Handler) collectExport(r *http.Request, userID int) (*accountExport, error) {
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// page through every todo in a stable order
+	todos := make([]*types.Todo, 0)
+	query := types.TodoQuery{SortBy: "id", Limit: 200}
+	for {
+		page, err := h.todos.GetTodos(r.Context(), userID, query)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, page.Todos...)
+		if page.NextCursor == nil {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	events, err := h.attempts.GetLoginAttempts(r.Context(), userID, maxExportEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountExport{
+		ExportedAt:     time.Now().UTC().Truncate(time.Second),
+		Profile:        user,
+		Todos:          todos,
+		SecurityEvents: events,
+	}, nil
+}
+
+func zipExport(export *accountExport) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"todos.json", export.Todos},
+		{"security-events.json", export.SecurityEvents},
+	}
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
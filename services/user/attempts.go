@@ -0,0 +1,99 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+func (s *Store) RecordLoginAttempt(ctx context.Context, attempt types.LoginAttempt) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	// attempts against unknown identifiers are kept without a user
+	var userID any
+	if attempt.UserID != 0 {
+		userID = attempt.UserID
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO login_attempt (user_id, identifier, ip, user_agent, success, reason) VALUES (?,?,?,?,?,?)",
+		userID, attempt.Identifier, attempt.IP, attempt.UserAgent, attempt.Success, attempt.Reason,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) GetLoginAttempts(ctx context.Context, userID int, limit int) ([]*types.LoginAttempt, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, user_id, identifier, ip, user_agent, success, reason, created_at FROM login_attempt WHERE user_id = ? ORDER BY id DESC LIMIT ?",
+		userID, limit,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer rows.Close()
+
+	attempts := make([]*types.LoginAttempt, 0)
+	for rows.Next() {
+		attempt := new(types.LoginAttempt)
+		var userID sql.NullInt64
+		err := rows.Scan(
+			&attempt.ID,
+			&userID,
+			&attempt.Identifier,
+			&attempt.IP,
+			&attempt.UserAgent,
+			&attempt.Success,
+			&attempt.Reason,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
+		}
+		attempt.UserID = int(userID.Int64)
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return attempts, nil
+}
+
+func (s *Store) GetLoginFailures(ctx context.Context, userID int) (int, *time.Time, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	// ids rather than timestamps order attempts made within the same second
+	const since = "user_id = ? AND success = ? AND reason IN (?, ?) AND id > COALESCE((SELECT MAX(id) FROM login_attempt WHERE user_id = ? AND success = ?), 0)"
+	args := []any{userID, false, types.LoginInvalidPassword, types.LoginInvalidMFACode, userID, true}
+
+	var failures int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_attempt WHERE "+since, args...).Scan(&failures); err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return 0, nil, db.Error(ctx, err)
+	}
+	if failures == 0 {
+		return 0, nil, nil
+	}
+
+	var last time.Time
+	if err := s.db.QueryRowContext(ctx, "SELECT created_at FROM login_attempt WHERE "+since+" ORDER BY id DESC LIMIT 1", args...).Scan(&last); err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return 0, nil, db.Error(ctx, err)
+	}
+	return failures, &last, nil
+}
@@ -0,0 +1,361 @@
+package user_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/mailer"
+	"github.com/Waris-Shaik/todo/passwordpolicy"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/services/todo"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	keys, err := auth.NewKeySet(auth.KeyConfig{Secret: "test-secret", Issuer: "http://test", Audience: "todo-api"})
+	if err != nil {
+		panic(err)
+	}
+	auth.Keys = keys
+
+	// two-factor authentication is only offered with an encryption key
+	secrets, err := auth.NewSecretCipher(base64.StdEncoding.EncodeToString(make([]byte, 32)), nil)
+	if err != nil {
+		panic(err)
+	}
+	auth.Secrets = secrets
+
+	os.Exit(m.Run())
+}
+
+var passwordPolicy = passwordpolicy.Policy{MinLength: 8}
+
+// testServer is a user Handler on in-memory stores.
+type testServer struct {
+	t      *testing.T
+	router *mux.Router
+	users  *user.MemoryStore
+	todos  *todo.MemoryStore
+	tokens *auth.MemoryStore
+}
+
+func newTestServer(t *testing.T, limits user.Limits) *testServer {
+	return newHandlerTestServer(t, limits, user.DeletionPolicy{}, user.SSO{})
+}
+
+// newSSOTestServer is newTestServer with single sign-on configured by sso.
+func newSSOTestServer(t *testing.T, limits user.Limits, sso user.SSO) *testServer {
+	return newHandlerTestServer(t, limits, user.DeletionPolicy{}, sso)
+}
+
+// newDeletionTestServer is newTestServer keeping deleted accounts as deletion
+// says.
+func newDeletionTestServer(t *testing.T, deletion user.DeletionPolicy) *testServer {
+	return newHandlerTestServer(t, user.Limits{}, deletion, user.SSO{})
+}
+
+func newHandlerTestServer(t *testing.T, limits user.Limits, deletion user.DeletionPolicy, sso user.SSO) *testServer {
+	users := user.NewMemoryStore()
+	todos := todo.NewMemoryStore()
+	tokens := auth.NewMemoryStore()
+	handler := user.NewHandler(users, todos, users, tokens, tokens, tokens, tokens, users, users,
+		limits, user.Emails{Mailer: mailer.LogMailer{}, BaseURL: "http://test"}, deletion, passwordPolicy, sso, user.TwoFactor{Issuer: "Todo"})
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	return &testServer{t: t, router: router, users: users, todos: todos, tokens: tokens}
+}
+
+// do sends body as JSON, with token as a Bearer token when set, and decodes
+// the response into a map.
+func (s *testServer) do(method, path string, body any, token string) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		s.t.Fatalf("%s %s answered %d with %q, not JSON", method, path, w.Code, w.Body.String())
+	}
+	return w, response
+}
+
+const testPassword = "kitchen-lamp-river"
+
+// register creates jane and returns her access token.
+func (s *testServer) register() string {
+	s.t.Helper()
+	w, response := s.do(http.MethodPost, "/register?include_token=true", map[string]string{
+		"first_name": "Jane",
+		"last_name":  "Doe",
+		"username":   "jane",
+		"email":      "jane@example.com",
+		"password":   testPassword,
+	}, "")
+	if w.Code != http.StatusCreated {
+		s.t.Fatalf("register answered %d: %v", w.Code, response)
+	}
+	return response["access_token"].(string)
+}
+
+// login posts jane's credentials with password.
+func (s *testServer) login(password string) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	return s.do(http.MethodPost, "/login?include_token=true", map[string]string{"text": "jane", "password": password}, "")
+}
+
+// hasCookie reports whether w sets a non-empty cookie called name.
+func hasCookie(w *httptest.ResponseRecorder, name string) bool {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// jane returns the user register created.
+func (s *testServer) jane() *types.User {
+	s.t.Helper()
+	u, err := s.users.GetUserByUsername(context.Background(), "jane")
+	if err != nil {
+		s.t.Fatalf("looking up jane: %v", err)
+	}
+	return u
+}
+
+func TestDeleteAccountNeedsPassword(t *testing.T) {
+	s := newDeletionTestServer(t, user.DeletionPolicy{Grace: time.Hour})
+	token := s.register()
+
+	w, response := s.do(http.MethodDelete, "/users/me", map[string]string{"password": "wrong-password"}, token)
+	if w.Code != http.StatusUnauthorized || response["code"] != "invalid_password" {
+		t.Fatalf("delete with a wrong password answered %d: %v, want 401 invalid_password", w.Code, response)
+	}
+	if w, response := s.do(http.MethodDelete, "/users/me", map[string]string{}, token); w.Code != http.StatusBadRequest {
+		t.Fatalf("delete without a password answered %d: %v, want 400", w.Code, response)
+	}
+
+	if s.jane().DeletedAt != nil {
+		t.Fatal("account marked deleted without the password")
+	}
+	if w, response := s.do(http.MethodGet, "/users/me", nil, token); w.Code != http.StatusOK {
+		t.Fatalf("session after a refused delete answered %d: %v", w.Code, response)
+	}
+}
+
+func TestDeleteAccountAndRestore(t *testing.T) {
+	grace := time.Hour
+	s := newDeletionTestServer(t, user.DeletionPolicy{Grace: grace})
+	token := s.register()
+
+	w, response := s.do(http.MethodDelete, "/users/me", map[string]string{"password": testPassword}, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete answered %d: %v", w.Code, response)
+	}
+	purgeAt, err := time.Parse(time.RFC3339, response["purge_at"].(string))
+	if err != nil {
+		t.Fatalf("purge_at %v: %v", response["purge_at"], err)
+	}
+	if want := time.Now().Add(grace); purgeAt.Before(want.Add(-2*time.Second)) || purgeAt.After(want) {
+		t.Errorf("purge_at = %v, want about %v", purgeAt, want)
+	}
+	if s.jane().DeletedAt == nil {
+		t.Fatal("deleted account has no DeletedAt")
+	}
+	if w, _ := s.do(http.MethodGet, "/users/me", nil, token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("session after deleting the account answered %d, want 401", w.Code)
+	}
+
+	// logging in during the grace period keeps the account
+	w, response = s.login(testPassword)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login during the grace period answered %d: %v", w.Code, response)
+	}
+	if message, _ := response["message"].(string); !strings.Contains(message, "no longer scheduled for deletion") {
+		t.Errorf("message %q does not say the deletion was cancelled", message)
+	}
+	if s.jane().DeletedAt != nil {
+		t.Fatal("login did not restore the account")
+	}
+
+	purged, err := user.NewPurger(s.users, s.todos, user.DeletionPolicy{}).Purge(context.Background())
+	if err != nil || purged != 0 {
+		t.Fatalf("Purge after restoring = %d, %v; want 0", purged, err)
+	}
+}
+
+func TestDeletedAccountAfterGracePeriod(t *testing.T) {
+	policy := user.DeletionPolicy{Grace: time.Hour}
+	s := newDeletionTestServer(t, policy)
+	s.register()
+	jane := s.jane()
+	if err := s.todos.CreateTodo(context.Background(), types.Todo{Title: "water the plants", UserID: jane.ID}); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// deleted longer ago than the grace period
+	if err := s.users.DeleteUser(context.Background(), jane.ID, time.Now().Add(-policy.Grace-time.Minute)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if w, response := s.login(testPassword); w.Code != http.StatusNotFound || response["code"] != "user_not_found" {
+		t.Fatalf("login after the grace period answered %d: %v, want 404 user_not_found", w.Code, response)
+	}
+
+	purged, err := user.NewPurger(s.users, s.todos, policy).Purge(context.Background())
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge = %d, %v; want 1", purged, err)
+	}
+	if _, err := s.users.GetUserByID(context.Background(), jane.ID); err == nil {
+		t.Fatal("purged user still exists")
+	}
+	page, err := s.todos.GetTodos(context.Background(), jane.ID, types.TodoQuery{Limit: 10})
+	if err != nil || len(page.Todos) != 0 {
+		t.Fatalf("todos after purge = %v, %v; want none", page, err)
+	}
+}
+
+// exportFixture registers jane with two todos and a failed login and returns
+// her access token.
+func (s *testServer) exportFixture() string {
+	s.t.Helper()
+	token := s.register()
+	for _, title := range []string{"water the plants", "call the bank"} {
+		if err := s.todos.CreateTodo(context.Background(), types.Todo{Title: title, UserID: s.jane().ID}); err != nil {
+			s.t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+	if w, _ := s.login("wrong-password"); w.Code != http.StatusUnauthorized {
+		s.t.Fatalf("login with a wrong password answered %d", w.Code)
+	}
+	return token
+}
+
+// checkExport checks the profile, todos and security events of an export
+// made by exportFixture, each as JSON.
+func checkExport(t *testing.T, profile, todos, events []byte) {
+	t.Helper()
+	var p map[string]any
+	if err := json.Unmarshal(profile, &p); err != nil {
+		t.Fatalf("profile %s: %v", profile, err)
+	}
+	if p["username"] != "jane" || p["email"] != "jane@example.com" {
+		t.Errorf("profile = %v, want jane's", p)
+	}
+	if bytes.Contains(profile, []byte("password")) {
+		t.Errorf("profile %s includes the password", profile)
+	}
+
+	var ts []types.Todo
+	if err := json.Unmarshal(todos, &ts); err != nil {
+		t.Fatalf("todos %s: %v", todos, err)
+	}
+	if len(ts) != 2 || ts[0].Title != "water the plants" || ts[1].Title != "call the bank" {
+		t.Errorf("todos = %+v, want both of jane's in order", ts)
+	}
+
+	var es []types.LoginAttempt
+	if err := json.Unmarshal(events, &es); err != nil {
+		t.Fatalf("security events %s: %v", events, err)
+	}
+	var failed bool
+	for _, e := range es {
+		failed = failed || (!e.Success && e.Reason == types.LoginInvalidPassword)
+	}
+	if !failed {
+		t.Errorf("security events = %+v, want the failed login", es)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	token := s.exportFixture()
+
+	w, _ := s.do(http.MethodGet, "/users/me/export", nil, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export answered %d: %s", w.Code, w.Body)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") || !strings.Contains(disposition, ".json") {
+		t.Errorf("Content-Disposition = %q, want a JSON attachment", disposition)
+	}
+
+	var export struct {
+		Profile        json.RawMessage `json:"profile"`
+		Todos          json.RawMessage `json:"todos"`
+		SecurityEvents json.RawMessage `json:"security_events"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	checkExport(t, export.Profile, export.Todos, export.SecurityEvents)
+
+	if w, response := s.do(http.MethodGet, "/users/me/export?format=csv", nil, token); w.Code != http.StatusBadRequest {
+		t.Fatalf("export as CSV answered %d: %v, want 400", w.Code, response)
+	}
+}
+
+func TestExportZIP(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	token := s.exportFixture()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/me/export?format=zip", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export answered %d: %s", w.Code, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", contentType)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("reading the archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+		files[f.Name] = data
+	}
+	if len(files) != 3 {
+		t.Errorf("archive holds %d files, want 3", len(files))
+	}
+	checkExport(t, files["profile.json"], files["todos.json"], files["security-events.json"])
+}
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Waris-Shaik/todo/db"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+var (
+	ErrIdentityNotFound = types.NotFound("identity_not_found", "identity not found")
+	ErrIdentityLinked   = types.Conflict("identity_already_linked", "identity is already linked to a user")
+)
+
+func (s *Store) GetUserIdentity(ctx context.Context, issuer, subject string) (*types.UserIdentity, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, user_id, issuer, subject, email, created_at, last_login_at FROM user_identity WHERE issuer = ? AND subject = ?",
+		issuer, subject,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer rows.Close()
+
+	var identity *types.UserIdentity
+	for rows.Next() {
+		identity = new(types.UserIdentity)
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+
+	if identity == nil {
+		return nil, ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (s *Store) CreateUserIdentity(ctx context.Context, identity types.UserIdentity) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO user_identity (user_id, issuer, subject, email) VALUES (?,?,?,?)",
+		identity.UserID, identity.Issuer, identity.Subject, identity.Email,
+	)
+	if db.IsDuplicateKey(err) {
+		return 0, ErrIdentityLinked
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading last insert ID failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	return int(id), nil
+}
+
+func (s *Store) TouchUserIdentity(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE user_identity SET last_login_at = ? WHERE id = ?", at.UTC(), id); err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package user
+
+import "time"
+
+// LockoutPolicy locks an account after Threshold wrong passwords or two-factor
+// codes in a row. The first lockout lasts Base and each further failure
+// doubles it, up to Max. A zero Threshold disables lockouts.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// LockedUntil returns when an account with failures consecutive wrong
+// passwords, the latest at last, unlocks. It is zero when it is not locked.
+func (p LockoutPolicy) LockedUntil(failures int, last *time.Time) time.Time {
+	if p.Threshold <= 0 || failures < p.Threshold || last == nil {
+		return time.Time{}
+	}
+
+	lockout := p.Base
+	for i := p.Threshold; i < failures && lockout < p.Max; i++ {
+		lockout *= 2
+	}
+	if p.Max > 0 && lockout > p.Max {
+		lockout = p.Max
+	}
+	return last.Add(lockout)
+}
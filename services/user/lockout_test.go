@@ -0,0 +1,77 @@
+package user_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/services/user"
+)
+
+func TestLockoutPolicyLockedUntil(t *testing.T) {
+	last := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	policy := user.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   user.LockoutPolicy
+		failures int
+		last     *time.Time
+		want     time.Duration
+		unlocked bool
+	}{
+		{name: "below threshold", policy: policy, failures: 2, last: &last, unlocked: true},
+		{name: "at threshold", policy: policy, failures: 3, last: &last, want: time.Minute},
+		{name: "doubles", policy: policy, failures: 5, last: &last, want: 4 * time.Minute},
+		{name: "capped", policy: policy, failures: 50, last: &last, want: 10 * time.Minute},
+		{name: "no failure time", policy: policy, failures: 3, unlocked: true},
+		{name: "disabled", policy: user.LockoutPolicy{Base: time.Minute}, failures: 50, last: &last, unlocked: true},
+	}
+	for _, tt := range tests {
+		got := tt.policy.LockedUntil(tt.failures, tt.last)
+		if tt.unlocked {
+			if !got.IsZero() {
+				t.Errorf("%s: LockedUntil = %v, want unlocked", tt.name, got)
+			}
+			continue
+		}
+		if want := last.Add(tt.want); !got.Equal(want) {
+			t.Errorf("%s: LockedUntil = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestLoginLocksAfterRepeatedFailures(t *testing.T) {
+	s := newTestServer(t, user.Limits{Lockout: user.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour}})
+	s.register()
+
+	for i := 0; i < 3; i++ {
+		if w, response := s.login("wrong-password"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d answered %d: %v", i+1, w.Code, response)
+		}
+	}
+
+	// the right password does not help while the account is locked
+	w, response := s.login(testPassword)
+	if w.Code != http.StatusTooManyRequests || response["code"] != "account_locked" {
+		t.Fatalf("login while locked answered %d: %v, want 429 account_locked", w.Code, response)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("locked login has no Retry-After header")
+	}
+	if hasCookie(w, "token") {
+		t.Fatal("locked login set a session cookie")
+	}
+}
+
+func TestSuccessfulLoginResetsFailures(t *testing.T) {
+	s := newTestServer(t, user.Limits{Lockout: user.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour}})
+	s.register()
+
+	for _, password := range []string{"wrong-password", "wrong-password", testPassword, "wrong-password", "wrong-password"} {
+		s.login(password)
+	}
+	if w, response := s.login(testPassword); w.Code != http.StatusOK {
+		t.Fatalf("login after failures interrupted by a success answered %d: %v", w.Code, response)
+	}
+}
@@ -0,0 +1,411 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+// MemoryStore is a UserStore kept entirely in memory, for local runs and tests
+// without a database server. It mirrors the behaviour of Store.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	nextID     int
+	users      map[int]types.User
+	attempts   []types.LoginAttempt
+	identities []types.UserIdentity
+	// recoveryCodes holds the unused recovery code hashes of each user
+	recoveryCodes map[int][]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1, users: make(map[int]types.User), recoveryCodes: make(map[int][]string)}
+}
+
+func (s *MemoryStore) GetUserByEmail(_ context.Context, email string) (*types.User, error) {
+	email = utils.NormalizeEmail(email)
+	return s.findUser(func(user types.User) bool { return user.Email == email })
+}
+
+func (s *MemoryStore) GetUserByUsername(_ context.Context, username string) (*types.User, error) {
+	return s.findUser(func(user types.User) bool { return strings.EqualFold(user.UserName, username) })
+}
+
+func (s *MemoryStore) GetUserByLogin(ctx context.Context, login string) (*types.User, error) {
+	if strings.Contains(login, "@") {
+		return s.GetUserByEmail(ctx, login)
+	}
+	return s.GetUserByUsername(ctx, login)
+}
+
+func (s *MemoryStore) findUser(match func(types.User) bool) (*types.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if match(user) {
+			return &user, nil
+		}
+	}
+	return nil, types.NotFound("user_not_found", "user not found")
+}
+
+func (s *MemoryStore) GetUserByID(_ context.Context, id int) (*types.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, types.NotFound("user_not_found", "user not found")
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) CreateUser(_ context.Context, user types.User) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.Email = utils.NormalizeEmail(user.Email)
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return 0, ErrEmailTaken
+		}
+		if strings.EqualFold(existing.UserName, user.UserName) {
+			return 0, ErrUsernameTaken
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	user.ID = s.nextID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	s.users[user.ID] = user
+	s.nextID++
+	return user.ID, nil
+}
+
+func (s *MemoryStore) MarkEmailVerified(_ context.Context, id int, email string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.Email != email || user.EmailVerifiedAt != nil {
+		return false, nil
+	}
+	verifiedAt := at.UTC().Truncate(time.Second)
+	user.EmailVerifiedAt = &verifiedAt
+	user.UpdatedAt = verifiedAt
+	s.users[id] = user
+	return true, nil
+}
+
+func (s *MemoryStore) UpdatePassword(_ context.Context, id int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return types.NotFound("user_not_found", "user not found")
+	}
+	user.Password = passwordHash
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(_ context.Context, id int, payload types.UserUpdatePayload) (*types.User, error) {
+	if payload.FirstName == nil && payload.LastName == nil && payload.UserName == nil {
+		return nil, types.Validation("validation_failed", "nothing to update")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, types.NotFound("user_not_found", "user not found")
+	}
+	if payload.UserName != nil {
+		for _, other := range s.users {
+			if other.ID != id && strings.EqualFold(other.UserName, *payload.UserName) {
+				return nil, ErrUsernameTaken
+			}
+		}
+		user.UserName = *payload.UserName
+	}
+	if payload.FirstName != nil {
+		user.FirstName = *payload.FirstName
+	}
+	if payload.LastName != nil {
+		user.LastName = *payload.LastName
+	}
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[id] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) DeleteUser(_ context.Context, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return types.NotFound("user_not_found", "user not found")
+	}
+	deletedAt := at.UTC().Truncate(time.Second)
+	user.DeletedAt = &deletedAt
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) RestoreUser(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt == nil {
+		return nil
+	}
+	user.DeletedAt = nil
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) GetUsersDeletedBefore(_ context.Context, before time.Time, limit int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0)
+	for id := 1; id < s.nextID && len(ids) < limit; id++ {
+		user, ok := s.users[id]
+		if ok && user.DeletedAt != nil && user.DeletedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) PurgeUser(_ context.Context, id int, deletedBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt == nil || !user.DeletedAt.Before(deletedBefore) {
+		return false, nil
+	}
+	delete(s.users, id)
+	delete(s.recoveryCodes, id)
+
+	// login attempts, identities and recovery codes cascade with the user, as in the database
+	attempts := s.attempts[:0]
+	for _, attempt := range s.attempts {
+		if attempt.UserID != id {
+			attempts = append(attempts, attempt)
+		}
+	}
+	s.attempts = attempts
+
+	identities := s.identities[:0]
+	for _, identity := range s.identities {
+		if identity.UserID != id {
+			identities = append(identities, identity)
+		}
+	}
+	s.identities = identities
+	return true, nil
+}
+
+func (s *MemoryStore) RecordLoginAttempt(_ context.Context, attempt types.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt.ID = 1
+	if n := len(s.attempts); n > 0 {
+		attempt.ID = s.attempts[n-1].ID + 1
+	}
+	attempt.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func (s *MemoryStore) GetLoginAttempts(_ context.Context, userID int, limit int) ([]*types.LoginAttempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attempts := make([]*types.LoginAttempt, 0)
+	for i := len(s.attempts) - 1; i >= 0 && len(attempts) < limit; i-- {
+		if s.attempts[i].UserID == userID {
+			attempt := s.attempts[i]
+			attempts = append(attempts, &attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (s *MemoryStore) GetLoginFailures(_ context.Context, userID int) (int, *time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var failures int
+	var last *time.Time
+	for i := len(s.attempts) - 1; i >= 0; i-- {
+		attempt := s.attempts[i]
+		if attempt.UserID != userID {
+			continue
+		}
+		if attempt.Success {
+			break
+		}
+		if attempt.Reason != types.LoginInvalidPassword && attempt.Reason != types.LoginInvalidMFACode {
+			continue
+		}
+		if last == nil {
+			last = &attempt.CreatedAt
+		}
+		failures++
+	}
+	return failures, last, nil
+}
+
+func (s *MemoryStore) GetUserIdentity(_ context.Context, issuer, subject string) (*types.UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, identity := range s.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func (s *MemoryStore) CreateUserIdentity(_ context.Context, identity types.UserIdentity) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[identity.UserID]; !ok {
+		return 0, types.NotFound("user_not_found", "user not found")
+	}
+	identity.ID = 1
+	for _, existing := range s.identities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return 0, ErrIdentityLinked
+		}
+		identity.ID = existing.ID + 1
+	}
+	identity.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	identity.LastLoginAt = nil
+	s.identities = append(s.identities, identity)
+	return identity.ID, nil
+}
+
+func (s *MemoryStore) TouchUserIdentity(_ context.Context, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.identities {
+		if s.identities[i].ID == id {
+			at := at.UTC()
+			s.identities[i].LastLoginAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetTOTPSecret(_ context.Context, userID int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	user.TOTPSecret = secret
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStore) EnableTOTP(_ context.Context, userID int, step int64, at time.Time, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.TOTPSecret == "" || user.TOTPEnabledAt != nil {
+		return ErrMFANotEnrolled
+	}
+	enabledAt := at.UTC().Truncate(time.Second)
+	user.TOTPEnabledAt = &enabledAt
+	user.TOTPLastStep = step
+	user.UpdatedAt = enabledAt
+	s.users[userID] = user
+	s.recoveryCodes[userID] = append([]string(nil), recoveryCodeHashes...)
+	return nil
+}
+
+func (s *MemoryStore) DisableTOTP(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.users[userID] = user
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *MemoryStore) UseTOTPStep(_ context.Context, userID int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	s.users[userID] = user
+	return true, nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(_ context.Context, userID int, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recoveryCodes[userID] = append([]string(nil), hashes...)
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(_ context.Context, userID int, hash string, _ time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := s.recoveryCodes[userID]
+	for i, code := range codes {
+		if code == hash {
+			s.recoveryCodes[userID] = append(codes[:i:i], codes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) CountRecoveryCodes(_ context.Context, userID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.recoveryCodes[userID]), nil
+}
@@ -0,0 +1,440 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+// TwoFactor configures two-factor authentication with authenticator apps. It
+// is off unless auth.Secrets is set, as TOTP secrets are stored encrypted.
+type TwoFactor struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+}
+
+var (
+	ErrMFANotEnrolled      = types.Conflict("mfa_not_enrolled", "start two-factor enrollment first")
+	errMFANotConfigured    = types.NotFound("mfa_not_configured", "two-factor authentication is not configured")
+	errMFAAlreadyEnabled   = types.Conflict("mfa_already_enabled", "two-factor authentication is already enabled")
+	errMFANotEnabled       = types.Conflict("mfa_not_enabled", "two-factor authentication is not enabled")
+	errInvalidMFACode      = types.Unauthorized("invalid_mfa_code", "invalid or already used code")
+	errMFASecretUnreadable = fmt.Errorf("two-factor secret cannot be decrypted")
+)
+
+// handleEnrollTOTP starts enrollment with a new secret for the user's
+// authenticator app. Two-factor authentication stays off until a first code
+// is confirmed, so a secret the user never scanned cannot lock them out.
+func (h *Handler) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if auth.Secrets == nil {
+		utils.WriteError(w, errMFANotConfigured)
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		utils.WriteError(w, errMFAAlreadyEnabled)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mfa enroll: generating secret failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	encrypted, err := auth.Secrets.Encrypt(user.ID, secret)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mfa enroll: encrypting secret failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	if err := h.mfaStore.SetTOTPSecret(r.Context(), user.ID, encrypted); err != nil {
+		slog.ErrorContext(r.Context(), "mfa enroll: storing secret failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "mfa enrollment started")
+
+	response := struct {
+		Success    bool   `json:"success"`
+		Message    string `json:"message"`
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Success:    true,
+		Message:    "add the secret to your authenticator app, then confirm with a code from it",
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(h.twoFactor.Issuer, user.Email, secret),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// handleConfirmTOTP turns two-factor authentication on once the user proves
+// their app has the secret, and hands out the recovery codes.
+func (h *Handler) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var payload types.MFACodePayload
+	if !parseMFACode(w, r, &payload) {
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("mfa:user:%d", user.ID)) {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		utils.WriteError(w, errMFAAlreadyEnabled)
+		return
+	}
+	if user.TOTPSecret == "" {
+		utils.WriteError(w, ErrMFANotEnrolled)
+		return
+	}
+
+	secret, err := h.totpSecret(user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mfa confirm: reading secret failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	now := time.Now().Truncate(time.Second)
+	step, ok := auth.MatchTOTP(secret, payload.Code, now)
+	if !ok {
+		slog.InfoContext(r.Context(), "mfa confirm: wrong code")
+		utils.WriteError(w, errInvalidMFACode)
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mfa confirm: generating recovery codes failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	if err := h.mfaStore.EnableTOTP(r.Context(), user.ID, step, now, hashes); err != nil {
+		slog.ErrorContext(r.Context(), "mfa confirm: enabling failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "mfa enabled")
+
+	response := struct {
+		Success       bool     `json:"success"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		Success:       true,
+		Message:       "two-factor authentication enabled, keep the recovery codes somewhere safe, they are not shown again",
+		RecoveryCodes: codes,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// handleDisableTOTP turns two-factor authentication off. It takes a current
+// code, so a stolen session alone cannot remove the second factor.
+func (h *Handler) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var payload types.MFACodePayload
+	if !parseMFACode(w, r, &payload) {
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("mfa:user:%d", user.ID)) {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		utils.WriteError(w, errMFANotEnabled)
+		return
+	}
+
+	if _, err := h.checkSecondFactor(r.Context(), user, payload.Code); err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			slog.InfoContext(r.Context(), "mfa disable: wrong code")
+		} else {
+			slog.ErrorContext(r.Context(), "mfa disable: checking code failed", "err", err)
+		}
+		utils.WriteError(w, err)
+		return
+	}
+	if err := h.mfaStore.DisableTOTP(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "mfa disable: disabling failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "mfa disabled")
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "two-factor authentication disabled",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// handleRegenerateRecoveryCodes replaces all recovery codes, used or not,
+// with a fresh set.
+func (h *Handler) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var payload types.MFACodePayload
+	if !parseMFACode(w, r, &payload) {
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("mfa:user:%d", user.ID)) {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		utils.WriteError(w, errMFANotEnabled)
+		return
+	}
+
+	if _, err := h.checkSecondFactor(r.Context(), user, payload.Code); err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			slog.InfoContext(r.Context(), "mfa recovery codes: wrong code")
+		} else {
+			slog.ErrorContext(r.Context(), "mfa recovery codes: checking code failed", "err", err)
+		}
+		utils.WriteError(w, err)
+		return
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mfa recovery codes: generating failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	if err := h.mfaStore.ReplaceRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		slog.ErrorContext(r.Context(), "mfa recovery codes: storing failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "mfa recovery codes regenerated")
+
+	response := struct {
+		Success       bool     `json:"success"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		Success:       true,
+		Message:       "new recovery codes generated, the old ones no longer work",
+		RecoveryCodes: codes,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// startMFALogin answers a correct password for a user with two-factor
+// authentication on. No session starts until /login/mfa gets a code.
+func (h *Handler) startMFALogin(w http.ResponseWriter, r *http.Request, user *types.User) {
+	token, err := auth.CreateMFAToken(user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "login: creating mfa token failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "login: second factor required", "login_user_id", user.ID)
+
+	response := struct {
+		Success     bool   `json:"success"`
+		Message     string `json:"message"`
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{
+		Success:     true,
+		Message:     "enter a code from your authenticator app or a recovery code",
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(auth.MFATokenTTL.Seconds()),
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// handleLoginMFA finishes a login started by handleLogin with a code from an
+// authenticator app or a recovery code. Wrong codes count towards the account
+// lockout like wrong passwords do.
+func (h *Handler) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	withTokens, err := includeTokens(r)
+	if err != nil {
+		utils.WriteError(w, err)
+		return
+	}
+
+	var payload types.MFALoginPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	userID, err := auth.ParseMFAToken(payload.MFAToken)
+	if err != nil {
+		slog.InfoContext(r.Context(), "login mfa: invalid token", "err", err)
+		utils.WriteError(w, auth.ErrMFATokenInvalid)
+		return
+	}
+
+	// throttle guesses against a single account, as the password step does
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("login:mfa:%d", userID)) {
+		return
+	}
+
+	// the account may have been purged or had two-factor turned off since
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err == nil && user.DeletedAt != nil && !time.Now().Before(h.deletion.PurgeAt(*user.DeletedAt)) {
+		err = types.ErrNotFound
+	}
+	if err == nil && user.TOTPEnabledAt == nil {
+		err = types.ErrNotFound
+	}
+	if errors.Is(err, types.ErrNotFound) {
+		slog.InfoContext(r.Context(), "login mfa: user no longer needs a second factor", "login_user_id", userID)
+		utils.WriteError(w, auth.ErrMFATokenInvalid)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "login mfa: looking up user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	if !h.checkLockout(w, r, user, user.UserName) {
+		return
+	}
+
+	usedRecoveryCode, err := h.checkSecondFactor(r.Context(), user, payload.Code)
+	if errors.Is(err, errInvalidMFACode) {
+		slog.InfoContext(r.Context(), "login mfa: wrong code", "login_user_id", user.ID)
+		h.recordLoginAttempt(r, user.ID, user.UserName, types.LoginInvalidMFACode)
+		utils.WriteError(w, err)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "login mfa: checking code failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// warn users running out of recovery codes
+	var note string
+	if usedRecoveryCode {
+		left, err := h.mfaStore.CountRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "login mfa: counting recovery codes failed", "err", err)
+			utils.WriteError(w, err)
+			return
+		}
+		note = fmt.Sprintf("you have %d recovery codes left", left)
+	}
+
+	h.completeLogin(w, r, user, user.UserName, withTokens, note)
+}
+
+// checkSecondFactor accepts a code from the user's authenticator app or one
+// of their recovery codes, and reports which it was. Either can only be used
+// once.
+func (h *Handler) checkSecondFactor(ctx context.Context, user *types.User, code string) (bool, error) {
+	if !auth.IsTOTPCode(code) {
+		used, err := h.mfaStore.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code), time.Now())
+		if err != nil {
+			return false, err
+		}
+		if !used {
+			return false, errInvalidMFACode
+		}
+		return true, nil
+	}
+
+	secret, err := h.totpSecret(user)
+	if err != nil {
+		return false, types.Internal(err)
+	}
+	step, ok := auth.MatchTOTP(secret, code, time.Now())
+	if !ok {
+		return false, errInvalidMFACode
+	}
+	used, err := h.mfaStore.UseTOTPStep(ctx, user.ID, step)
+	if err != nil {
+		return false, err
+	}
+	if !used {
+		return false, errInvalidMFACode
+	}
+	return false, nil
+}
+
+// totpSecret decrypts the user's stored TOTP secret.
+func (h *Handler) totpSecret(user *types.User) (string, error) {
+	if auth.Secrets == nil {
+		return "", errMFASecretUnreadable
+	}
+	secret, err := auth.Secrets.Decrypt(user.ID, user.TOTPSecret)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errMFASecretUnreadable, err)
+	}
+	return secret, nil
+}
+
+// currentUser loads the authenticated user of the request.
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (*types.User, bool) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return nil, false
+	}
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading user failed", "err", err)
+		utils.WriteError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func parseMFACode(w http.ResponseWriter, r *http.Request, payload *types.MFACodePayload) bool {
+	if err := utils.ParseJSON(r, payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return false
+	}
+	if err := utils.Validate(payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return false
+	}
+	return true
+}
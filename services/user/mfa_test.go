@@ -0,0 +1,178 @@
+package user_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/services/user"
+)
+
+// totpCode computes the code an authenticator app shows for secret at t, as in
+// RFC 6238.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decoding secret %q: %v", secret, err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// enableTOTP turns two-factor authentication on for the user token belongs to
+// and returns the secret and recovery codes.
+func (s *testServer) enableTOTP(token string) (string, []string) {
+	s.t.Helper()
+	w, response := s.do(http.MethodPost, "/users/me/mfa/totp", nil, token)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("enroll answered %d: %v", w.Code, response)
+	}
+	secret := response["secret"].(string)
+	if uri, _ := response["otpauth_uri"].(string); !strings.Contains(uri, "secret="+secret) {
+		s.t.Fatalf("otpauth_uri %q does not carry the secret", uri)
+	}
+
+	w, response = s.do(http.MethodPost, "/users/me/mfa/totp/confirm", map[string]string{"code": totpCode(s.t, secret, time.Now())}, token)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("confirm answered %d: %v", w.Code, response)
+	}
+	var codes []string
+	for _, code := range response["recovery_codes"].([]any) {
+		codes = append(codes, code.(string))
+	}
+	return secret, codes
+}
+
+// startLogin logs jane in with her password and returns the MFA token.
+func (s *testServer) startLogin() string {
+	s.t.Helper()
+	w, response := s.login(testPassword)
+	if w.Code != http.StatusOK || response["mfa_required"] != true {
+		s.t.Fatalf("login answered %d: %v, want mfa_required", w.Code, response)
+	}
+	if hasCookie(w, "token") || response["access_token"] != nil {
+		s.t.Fatal("password alone started a session")
+	}
+	return response["mfa_token"].(string)
+}
+
+// loginMFA finishes a login started by startLogin with code.
+func (s *testServer) loginMFA(mfaToken, code string) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	return s.do(http.MethodPost, "/login/mfa?include_token=true", map[string]string{"mfa_token": mfaToken, "code": code}, "")
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	secret, _ := s.enableTOTP(s.register())
+
+	// the code used to confirm is spent, so use the next one, which the skew allows
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+	w, response := s.loginMFA(s.startLogin(), code)
+	if w.Code != http.StatusOK || !hasCookie(w, "token") {
+		t.Fatalf("login with a code answered %d: %v, want a session", w.Code, response)
+	}
+	if _, ok := response["access_token"].(string); !ok {
+		t.Fatalf("login with a code returned no access token: %v", response)
+	}
+
+	// a code works once, even within its time step
+	w, response = s.loginMFA(s.startLogin(), code)
+	if w.Code != http.StatusUnauthorized || response["code"] != "invalid_mfa_code" {
+		t.Fatalf("replayed code answered %d: %v, want 401 invalid_mfa_code", w.Code, response)
+	}
+
+	if w, response := s.loginMFA("not-a-token", code); w.Code != http.StatusUnauthorized || response["code"] != "invalid_mfa_token" {
+		t.Fatalf("login with an invalid MFA token answered %d: %v", w.Code, response)
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	s.enableTOTP(s.register())
+
+	if w, response := s.do(http.MethodGet, "/users/me", nil, s.startLogin()); w.Code != http.StatusUnauthorized {
+		t.Fatalf("MFA token as an access token answered %d: %v", w.Code, response)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	_, codes := s.enableTOTP(s.register())
+	if len(codes) == 0 {
+		t.Fatal("confirming gave no recovery codes")
+	}
+
+	// typed back in upper case without the dash, the code still works
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	w, response := s.loginMFA(s.startLogin(), typed)
+	if w.Code != http.StatusOK || !hasCookie(w, "token") {
+		t.Fatalf("login with a recovery code answered %d: %v", w.Code, response)
+	}
+	if message, _ := response["message"].(string); !strings.Contains(message, fmt.Sprintf("%d recovery codes left", len(codes)-1)) {
+		t.Errorf("message %q does not count the codes left", message)
+	}
+
+	if w, response := s.loginMFA(s.startLogin(), codes[0]); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code answered %d: %v", w.Code, response)
+	}
+	if w, response := s.loginMFA(s.startLogin(), codes[1]); w.Code != http.StatusOK {
+		t.Fatalf("second recovery code answered %d: %v", w.Code, response)
+	}
+}
+
+func TestWrongCodesLockTheAccount(t *testing.T) {
+	s := newTestServer(t, user.Limits{Lockout: user.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour}})
+	secret, _ := s.enableTOTP(s.register())
+
+	mfaToken := s.startLogin()
+	for i := 0; i < 3; i++ {
+		if w, response := s.loginMFA(mfaToken, "wrong-code"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d answered %d: %v", i+1, w.Code, response)
+		}
+	}
+
+	// neither the right code nor the password gets past the lockout
+	w, response := s.loginMFA(mfaToken, totpCode(t, secret, time.Now().Add(30*time.Second)))
+	if w.Code != http.StatusTooManyRequests || response["code"] != "account_locked" {
+		t.Fatalf("code while locked answered %d: %v, want 429 account_locked", w.Code, response)
+	}
+	if w, response := s.login(testPassword); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("password while locked answered %d: %v, want 429", w.Code, response)
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	token := s.register()
+	secret, _ := s.enableTOTP(token)
+
+	// a session alone cannot turn the second factor off
+	if w, response := s.do(http.MethodDelete, "/users/me/mfa/totp", map[string]string{"code": "wrong-code"}, token); w.Code != http.StatusUnauthorized {
+		t.Fatalf("disable with a wrong code answered %d: %v", w.Code, response)
+	}
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+	if w, response := s.do(http.MethodDelete, "/users/me/mfa/totp", map[string]string{"code": code}, token); w.Code != http.StatusOK {
+		t.Fatalf("disable answered %d: %v", w.Code, response)
+	}
+
+	w, response := s.login(testPassword)
+	if w.Code != http.StatusOK || response["mfa_required"] == true || !hasCookie(w, "token") {
+		t.Fatalf("login after disabling answered %d: %v, want a session", w.Code, response)
+	}
+}
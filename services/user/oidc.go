@@ -0,0 +1,366 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Waris-Shaik/todo/metrics"
+	"github.com/Waris-Shaik/todo/oidc"
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+// SSO configures logging in with an external OpenID Connect provider. A nil
+// Provider turns it off.
+type SSO struct {
+	Provider *oidc.Provider
+	// PostLoginURL is where the callback sends users once they are logged in.
+	// When empty the callback answers with JSON like /login does.
+	PostLoginURL string
+}
+
+const (
+	oidcFlowCookieName = "oidc_flow"
+	oidcFlowCookiePath = "/api/v1/oidc"
+	// oidcFlowTTL is how long a user has to log in at the provider
+	oidcFlowTTL = 10 * time.Minute
+	// maxUsernameAttempts bounds the suffixes tried to make a provisioned
+	// username unique
+	maxUsernameAttempts = 20
+	// maxProvisionedUsername is the longest username provisioning picks,
+	// suffix included
+	maxProvisionedUsername = 50
+)
+
+var (
+	errSSONotConfigured = types.NotFound("sso_not_configured", "single sign-on is not configured")
+	errSSOInvalidState  = types.Unauthorized("invalid_state", "login session expired or is invalid, please try again")
+	errSSOFailed        = types.Unauthorized("sso_failed", "could not log in with the identity provider")
+)
+
+// handleSSOLogin sends the user to the identity provider. The state, nonce
+// and PKCE verifier of the flow are kept in a short-lived cookie until the
+// provider sends the user back.
+func (h *Handler) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	if h.sso.Provider == nil {
+		utils.WriteError(w, errSSONotConfigured)
+		return
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sso: creating flow failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	redirectURL, err := h.sso.Provider.AuthCodeURL(r.Context(), flow, r.URL.Query().Get("login_hint"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sso: building authorization URL failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+
+	encoded, err := json.Marshal(flow)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sso: encoding flow failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	setCookie(w, oidcFlowCookieName, base64.RawURLEncoding.EncodeToString(encoded), oidcFlowCookiePath, time.Now().Add(oidcFlowTTL))
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// handleSSOCallback finishes the login the provider sent the user back from,
+// finding, linking or creating the user the identity belongs to, and starts
+// the same session as /login.
+func (h *Handler) handleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if h.sso.Provider == nil {
+		utils.WriteError(w, errSSONotConfigured)
+		return
+	}
+
+	// the flow is single use, whatever the outcome
+	flow := flowFromRequest(r)
+	setCookie(w, oidcFlowCookieName, "", oidcFlowCookiePath, time.Unix(0, 0))
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		slog.InfoContext(r.Context(), "sso: provider returned an error", "error", providerError, "description", query.Get("error_description"))
+		utils.WriteError(w, errSSOFailed)
+		return
+	}
+	if flow == nil || subtle.ConstantTimeCompare([]byte(flow.State), []byte(query.Get("state"))) != 1 {
+		slog.InfoContext(r.Context(), "sso: state missing or mismatched")
+		utils.WriteError(w, errSSOInvalidState)
+		return
+	}
+	if query.Get("code") == "" {
+		utils.WriteError(w, types.Validation("invalid_query", "code is required"))
+		return
+	}
+
+	identity, err := h.sso.Provider.Exchange(r.Context(), flow, query.Get("code"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "sso: exchanging code failed", "err", err)
+		utils.WriteError(w, errSSOFailed)
+		return
+	}
+
+	user, linkedID, err := h.userForIdentity(r.Context(), identity)
+	if err != nil {
+		slog.InfoContext(r.Context(), "sso: resolving user failed", "issuer", identity.Issuer, "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	if user.DeletedAt != nil && !time.Now().Before(h.deletion.PurgeAt(*user.DeletedAt)) {
+		utils.WriteError(w, types.NotFound("user_not_found", "user not found"))
+		return
+	}
+
+	// the provider vouches for the identity, not for the account: locked
+	// accounts stay locked and a second factor is still required
+	if !h.checkLockout(w, r, user, identity.Email) {
+		return
+	}
+
+	if err := h.identities.TouchUserIdentity(r.Context(), linkedID, time.Now().Truncate(time.Second)); err != nil {
+		slog.WarnContext(r.Context(), "sso: recording identity use failed", "err", err)
+	}
+
+	if user.TOTPEnabledAt != nil {
+		h.startSSOMFALogin(w, r, user)
+		return
+	}
+
+	// logging in to an account scheduled for deletion keeps it, as with /login
+	message := fmt.Sprintf("welcome back %v", user.UserName)
+	if user.DeletedAt != nil {
+		if err := h.store.RestoreUser(r.Context(), user.ID); err != nil {
+			slog.ErrorContext(r.Context(), "sso: restoring account failed", "err", err)
+			utils.WriteError(w, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "sso: account deletion cancelled", "login_user_id", user.ID)
+		message += ", your account is no longer scheduled for deletion"
+	}
+
+	if _, err := h.startSession(w, r, user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "starting session failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	h.recordSSOLogin(r, user.ID, identity.Email)
+
+	if h.sso.PostLoginURL != "" {
+		http.Redirect(w, r, h.sso.PostLoginURL, http.StatusFound)
+		return
+	}
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: message,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// startSSOMFALogin answers a provider login for a user with two-factor
+// authentication on like handleLogin answers a correct password. With a
+// post-login page the browser is sent there with the MFA token in the URL
+// fragment, which is not sent on to servers, for the page to finish the login
+// at /login/mfa.
+func (h *Handler) startSSOMFALogin(w http.ResponseWriter, r *http.Request, user *types.User) {
+	if h.sso.PostLoginURL == "" {
+		h.startMFALogin(w, r, user)
+		return
+	}
+
+	token, err := auth.CreateMFAToken(user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sso: creating mfa token failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+	slog.InfoContext(r.Context(), "sso: second factor required", "login_user_id", user.ID)
+
+	fragment := url.Values{"mfa_required": {"true"}, "mfa_token": {token}}
+	target, _, _ := strings.Cut(h.sso.PostLoginURL, "#")
+	http.Redirect(w, r, target+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// recordSSOLogin adds a successful login through the provider to the audit
+// trail. Failing to record does not fail the login.
+func (h *Handler) recordSSOLogin(r *http.Request, userID int, email string) {
+	metrics.LoginSucceeded()
+
+	err := h.attempts.RecordLoginAttempt(r.Context(), types.LoginAttempt{
+		UserID:     userID,
+		Identifier: truncate(email, 255),
+		IP:         ratelimit.ClientIP(r, h.limits.TrustProxy),
+		UserAgent:  truncate(r.UserAgent(), 512),
+		Success:    true,
+		Reason:     types.LoginSSO,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sso: recording login failed", "err", err)
+	}
+}
+
+// flowFromRequest returns the flow kept in the flow cookie, or nil.
+func flowFromRequest(r *http.Request) *oidc.Flow {
+	cookie, err := r.Cookie(oidcFlowCookieName)
+	if err != nil {
+		return nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	flow := new(oidc.Flow)
+	if err := json.Unmarshal(decoded, flow); err != nil || flow.State == "" {
+		return nil
+	}
+	return flow
+}
+
+// userForIdentity returns the user identity belongs to and the ID of its link.
+// An identity seen for the first time is linked to the user with its email
+// address, or to a new user when there is none.
+func (h *Handler) userForIdentity(ctx context.Context, identity *oidc.Identity) (*types.User, int, error) {
+	linked, err := h.identities.GetUserIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		user, err := h.store.GetUserByID(ctx, linked.UserID)
+		return user, linked.ID, err
+	}
+	if !errors.Is(err, types.ErrNotFound) {
+		return nil, 0, err
+	}
+
+	if identity.Email == "" {
+		return nil, 0, types.Forbidden("sso_email_required", "the identity provider did not share an email address")
+	}
+
+	user, err := h.store.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// both sides must have proven the address is theirs, or anyone who
+		// can sign up at the provider with it would take over the account
+		if !identity.EmailVerified || user.EmailVerifiedAt == nil {
+			return nil, 0, types.Conflict("sso_email_in_use", "an account with this email already exists, please login with your password")
+		}
+		slog.InfoContext(ctx, "sso: linking identity to existing user", "login_user_id", user.ID)
+	case errors.Is(err, types.ErrNotFound):
+		user, err = h.provisionUser(ctx, identity)
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, err
+	}
+
+	id, err := h.identities.CreateUserIdentity(ctx, types.UserIdentity{
+		UserID:  user.ID,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		Email:   identity.Email,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return user, id, nil
+}
+
+// provisionUser creates the user for an identity logging in for the first
+// time. The user gets an unusable random password; they can set one through
+// the forgotten password flow.
+func (h *Handler) provisionUser(ctx context.Context, identity *oidc.Identity) (*types.User, error) {
+	password, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := auth.HashPassword(&password)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName := identity.GivenName, identity.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(identity.Name), " ")
+	}
+	base := usernameFor(identity)
+	if firstName == "" {
+		firstName = base
+	}
+
+	user := types.User{
+		FirstName: truncate(firstName, 100),
+		LastName:  truncate(strings.TrimSpace(lastName), 100),
+		Email:     identity.Email,
+		Password:  hashedPassword,
+	}
+	for attempt := 1; ; attempt++ {
+		user.UserName = base
+		if attempt > 1 {
+			// shorten the base to make room for the suffix
+			suffix := strconv.Itoa(attempt)
+			user.UserName = truncate(base, maxProvisionedUsername-len(suffix)) + suffix
+		}
+		user.ID, err = h.store.CreateUser(ctx, user)
+		if !errors.Is(err, ErrUsernameTaken) || attempt == maxUsernameAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "sso: user provisioned", "created_user_id", user.ID)
+
+	if identity.EmailVerified {
+		if _, err := h.store.MarkEmailVerified(ctx, user.ID, utils.NormalizeEmail(identity.Email), time.Now()); err != nil {
+			return nil, err
+		}
+	} else if err := h.sendVerificationEmail(ctx, user.ID, user.FirstName, identity.Email); err != nil {
+		// the account works without it, as on registration
+		slog.ErrorContext(ctx, "sso: sending verification email failed", "err", err)
+	}
+
+	return h.store.GetUserByID(ctx, user.ID)
+}
+
+// usernameFor derives a username from the identity's preferred username or
+// email address, keeping only characters that are safe in a username.
+func usernameFor(identity *oidc.Identity) string {
+	candidate := identity.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(candidate) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return truncate(b.String(), maxProvisionedUsername)
+}
@@ -0,0 +1,320 @@
+package user_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Waris-Shaik/todo/oidc"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// ssoTestServer is a testServer logging in through a MockProvider.
+type ssoTestServer struct {
+	*testServer
+	mock *oidc.MockProvider
+}
+
+func newSSOTest(t *testing.T, limits user.Limits, postLoginURL string) *ssoTestServer {
+	t.Helper()
+	// the issuer is the server's URL, which is only known once it runs
+	var mock *oidc.MockProvider
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(provider.Close)
+
+	mock, err := oidc.NewMockProvider(provider.URL, "todo", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sso := user.SSO{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:       provider.URL,
+			ClientID:     "todo",
+			ClientSecret: "secret",
+			RedirectURL:  "http://test/api/v1/oidc/callback",
+		}),
+		PostLoginURL: postLoginURL,
+	}
+	return &ssoTestServer{testServer: newSSOTestServer(t, limits, sso), mock: mock}
+}
+
+// authorize starts a login as email and returns the flow cookie and the query
+// the provider sends the browser back with.
+func (s *ssoTestServer) authorize(email string) (*http.Cookie, url.Values) {
+	s.t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login?login_hint="+url.QueryEscape(email), nil))
+	if w.Code != http.StatusFound {
+		s.t.Fatalf("oidc login answered %d: %s", w.Code, w.Body.String())
+	}
+	var flow *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "oidc_flow" {
+			flow = cookie
+		}
+	}
+	if flow == nil {
+		s.t.Fatal("oidc login set no flow cookie")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("provider answered %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return flow, callback.Query()
+}
+
+// callback returns to the callback with query and, unless nil, the flow
+// cookie. The response is decoded when it is JSON.
+func (s *ssoTestServer) callback(flow *http.Cookie, query url.Values) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query.Encode(), nil)
+	if flow != nil {
+		r.AddCookie(flow)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+
+	var response map[string]any
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			s.t.Fatalf("callback answered %d with %q", w.Code, w.Body.String())
+		}
+	}
+	return w, response
+}
+
+// ssoLogin logs in through the provider as email.
+func (s *ssoTestServer) ssoLogin(email string) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	return s.callback(s.authorize(email))
+}
+
+// userByEmail returns the user with email, or nil.
+func (s *ssoTestServer) userByEmail(email string) *types.User {
+	s.t.Helper()
+	u, err := s.users.GetUserByEmail(context.Background(), email)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return u
+}
+
+// verifyEmail marks jane's email verified.
+func (s *ssoTestServer) verifyEmail() {
+	s.t.Helper()
+	jane := s.userByEmail("jane@example.com")
+	if _, err := s.users.MarkEmailVerified(context.Background(), jane.ID, jane.Email, time.Now()); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func TestSSOChecksState(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+
+	flow, query := s.authorize("jane@example.com")
+	forged := url.Values{"code": {query.Get("code")}, "state": {"forged"}}
+	if w, response := s.callback(flow, forged); w.Code != http.StatusUnauthorized || response["code"] != "invalid_state" {
+		t.Fatalf("callback with another state answered %d: %v", w.Code, response)
+	}
+	if w, response := s.callback(nil, query); w.Code != http.StatusUnauthorized || response["code"] != "invalid_state" {
+		t.Fatalf("callback without the flow cookie answered %d: %v", w.Code, response)
+	}
+}
+
+func TestSSOFlowIsSingleUse(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+
+	flow, query := s.authorize("jane@example.com")
+	w, response := s.callback(flow, query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback answered %d: %v", w.Code, response)
+	}
+	if hasCookie(w, "oidc_flow") {
+		t.Fatal("callback kept the flow cookie")
+	}
+
+	// a replayed callback fails at the provider, which only exchanges a code once
+	if w, response := s.callback(flow, query); w.Code != http.StatusUnauthorized || response["code"] != "sso_failed" {
+		t.Fatalf("replayed callback answered %d: %v", w.Code, response)
+	}
+	// and the old cookie does not fit a newer login
+	_, newer := s.authorize("jane@example.com")
+	if w, response := s.callback(flow, newer); w.Code != http.StatusUnauthorized || response["code"] != "invalid_state" {
+		t.Fatalf("old flow cookie on a newer login answered %d: %v", w.Code, response)
+	}
+}
+
+func TestSSOChecksNonce(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+
+	cookie, query := s.authorize("jane@example.com")
+	decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flow oidc.Flow
+	if err := json.Unmarshal(decoded, &flow); err != nil {
+		t.Fatal(err)
+	}
+	flow.Nonce = "other"
+	encoded, _ := json.Marshal(flow)
+	cookie.Value = base64.RawURLEncoding.EncodeToString(encoded)
+
+	if w, response := s.callback(cookie, query); w.Code != http.StatusUnauthorized || response["code"] != "sso_failed" {
+		t.Fatalf("callback with another nonce answered %d: %v", w.Code, response)
+	}
+	if s.userByEmail("jane@example.com") != nil {
+		t.Fatal("identity with the wrong nonce was provisioned")
+	}
+}
+
+func TestSSOProvisionsUsers(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+	s.register()
+
+	w, response := s.ssoLogin("john.smith@example.com")
+	if w.Code != http.StatusOK || !hasCookie(w, "token") {
+		t.Fatalf("first login answered %d: %v, want a session", w.Code, response)
+	}
+	john := s.userByEmail("john.smith@example.com")
+	if john == nil {
+		t.Fatal("first login provisioned no user")
+	}
+	if john.UserName != "john.smith" || john.FirstName != "John" || john.LastName != "Smith" || john.EmailVerifiedAt == nil {
+		t.Fatalf("provisioned %+v, want john.smith, John Smith, verified", john)
+	}
+
+	// logging in again finds the same user
+	if w, response := s.ssoLogin("john.smith@example.com"); w.Code != http.StatusOK {
+		t.Fatalf("second login answered %d: %v", w.Code, response)
+	}
+	if again := s.userByEmail("john.smith@example.com"); again.ID != john.ID {
+		t.Fatalf("second login found user %d, want %d", again.ID, john.ID)
+	}
+
+	// jane is taken, so jane at another domain gets a suffix
+	if w, response := s.ssoLogin("jane@corp.example"); w.Code != http.StatusOK {
+		t.Fatalf("login with a taken username answered %d: %v", w.Code, response)
+	}
+	if other := s.userByEmail("jane@corp.example"); other == nil || other.UserName != "jane2" {
+		t.Fatalf("provisioned %+v, want username jane2", other)
+	}
+
+	// long usernames are shortened to make room for the suffix
+	long := strings.Repeat("a", 60)
+	for i, want := range []string{strings.Repeat("a", 50), strings.Repeat("a", 49) + "2"} {
+		email := long + "@" + strconv.Itoa(i) + ".example"
+		if w, response := s.ssoLogin(email); w.Code != http.StatusOK {
+			t.Fatalf("login with a long username answered %d: %v", w.Code, response)
+		}
+		if got := s.userByEmail(email); got == nil || got.UserName != want {
+			t.Fatalf("provisioned %+v, want username %s", got, want)
+		}
+	}
+}
+
+func TestSSOLinksVerifiedEmail(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+	s.register()
+	jane := s.userByEmail("jane@example.com")
+
+	// jane has not proven the address is hers yet
+	if w, response := s.ssoLogin("jane@example.com"); w.Code != http.StatusConflict || response["code"] != "sso_email_in_use" {
+		t.Fatalf("login to an unverified account answered %d: %v", w.Code, response)
+	}
+
+	s.verifyEmail()
+	// nor has whoever signed up at the provider
+	s.mock.SetEmailVerified("jane@example.com", false)
+	if w, response := s.ssoLogin("jane@example.com"); w.Code != http.StatusConflict || response["code"] != "sso_email_in_use" {
+		t.Fatalf("login with an unverified provider email answered %d: %v", w.Code, response)
+	}
+
+	s.mock.SetEmailVerified("jane@example.com", true)
+	w, response := s.ssoLogin("jane@example.com")
+	if w.Code != http.StatusOK || !hasCookie(w, "token") {
+		t.Fatalf("login with both sides verified answered %d: %v", w.Code, response)
+	}
+	if linked := s.userByEmail("jane@example.com"); linked.ID != jane.ID {
+		t.Fatalf("login created user %d, want jane's account %d linked", linked.ID, jane.ID)
+	}
+}
+
+func TestSSOHonoursLockout(t *testing.T) {
+	s := newSSOTest(t, user.Limits{Lockout: user.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour}}, "")
+	s.register()
+	s.verifyEmail()
+
+	for i := 0; i < 3; i++ {
+		s.login("wrong-password")
+	}
+	w, response := s.ssoLogin("jane@example.com")
+	if w.Code != http.StatusTooManyRequests || response["code"] != "account_locked" {
+		t.Fatalf("login to a locked account answered %d: %v, want 429 account_locked", w.Code, response)
+	}
+	if hasCookie(w, "token") {
+		t.Fatal("login to a locked account set a session cookie")
+	}
+}
+
+func TestSSORequiresSecondFactor(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "")
+	secret, _ := s.enableTOTP(s.register())
+	s.verifyEmail()
+
+	w, response := s.ssoLogin("jane@example.com")
+	if w.Code != http.StatusOK || response["mfa_required"] != true || hasCookie(w, "token") {
+		t.Fatalf("login with two-factor on answered %d: %v, want mfa_required without a session", w.Code, response)
+	}
+	code := totpCode(t, secret, time.Now().Add(30*time.Second))
+	if w, response := s.loginMFA(response["mfa_token"].(string), code); w.Code != http.StatusOK || !hasCookie(w, "token") {
+		t.Fatalf("second step answered %d: %v", w.Code, response)
+	}
+}
+
+func TestSSORedirectsAfterLogin(t *testing.T) {
+	s := newSSOTest(t, user.Limits{}, "http://app.test/done")
+
+	w, _ := s.ssoLogin("john@example.com")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "http://app.test/done" || !hasCookie(w, "token") {
+		t.Fatalf("login answered %d to %q, want a session and a redirect to the post-login page", w.Code, w.Header().Get("Location"))
+	}
+
+	// with two-factor on, the page gets the MFA token in the fragment instead
+	token := s.register()
+	s.verifyEmail()
+	s.enableTOTP(token)
+	w, _ = s.ssoLogin("jane@example.com")
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil || hasCookie(w, "token") {
+		t.Fatalf("login with two-factor on answered %d to %q, want a redirect without a session", w.Code, w.Header().Get("Location"))
+	}
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if location.Host != "app.test" || location.RawQuery != "" || fragment.Get("mfa_required") != "true" || fragment.Get("mfa_token") == "" {
+		t.Fatalf("redirected to %q, want the MFA token in the fragment", location)
+	}
+}
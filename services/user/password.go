@@ -0,0 +1,293 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Waris-Shaik/todo/mailer"
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+type forgotPasswordPayload struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type resetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type changePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// handleForgotPassword emails a reset link. It answers the same whether or
+// not the account exists, so it cannot be used to find out who has one.
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	user, err := h.store.GetUserByEmail(r.Context(), payload.Email)
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		slog.InfoContext(r.Context(), "forgot password: user not found")
+	case err == nil && user.DeletedAt != nil:
+		slog.InfoContext(r.Context(), "forgot password: account scheduled for deletion")
+	case err != nil:
+		slog.ErrorContext(r.Context(), "forgot password: looking up user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	default:
+		// send in the background so the response time does not tell either
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Minute)
+		go func() {
+			defer cancel()
+			if err := h.sendPasswordResetEmail(ctx, user); err != nil {
+				slog.ErrorContext(ctx, "forgot password: sending reset email failed", "err", err)
+			}
+		}()
+	}
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "if an account exists for that address, a reset link is on its way",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) sendPasswordResetEmail(ctx context.Context, user *types.User) error {
+	token, err := auth.IssuePasswordResetToken(ctx, h.resets, user.ID)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", h.emails.PasswordResetURL, url.QueryEscape(token))
+	return h.emails.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. Open the link below to choose a new one. It expires in %d minutes and works once.\n\n%s\n\nIf it was not you, you can ignore this email and your password stays the same.\n",
+			user.FirstName, int(auth.PasswordResetTTL.Minutes()), link),
+	})
+}
+
+// handleResetPassword sets a new password with a token from a reset email and
+// signs the user out everywhere.
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// check the password before spending the token on it
+	userID, err := auth.CheckPasswordResetToken(r.Context(), h.resets, payload.Token)
+	if err != nil {
+		slog.InfoContext(r.Context(), "reset password: invalid token", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reset password: loading user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := h.passwords.Check(payload.Password, user.UserName, user.Email); err != nil {
+		slog.InfoContext(r.Context(), "password does not meet policy", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// consuming can still fail if the link was used concurrently
+	if _, err := auth.ConsumePasswordResetToken(r.Context(), h.resets, payload.Token); err != nil {
+		slog.InfoContext(r.Context(), "reset password: invalid token", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(&payload.Password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "hashing password failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := h.store.UpdatePassword(r.Context(), userID, hashedPassword); err != nil {
+		slog.ErrorContext(r.Context(), "reset password: updating password failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// whoever had the old password may hold sessions too
+	if err := auth.RevokeAllSessions(r.Context(), h.revocations, h.refreshStore, userID); err != nil {
+		slog.ErrorContext(r.Context(), "reset password: revoking sessions failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	clearSessionCookies(w)
+
+	// and personal access tokens, which would otherwise keep working for them
+	if err := h.accessTokens.RevokeUserPersonalAccessTokens(r.Context(), userID, time.Now()); err != nil {
+		slog.ErrorContext(r.Context(), "reset password: revoking personal access tokens failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	h.afterPasswordReset(r, user)
+	slog.InfoContext(r.Context(), "password reset", "reset_user_id", userID)
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "password has been reset, please login",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// afterPasswordReset records the reset on the security events page, which
+// also lifts a lockout, and verifies the email the link was sent to.
+func (h *Handler) afterPasswordReset(r *http.Request, user *types.User) {
+	err := h.attempts.RecordLoginAttempt(r.Context(), types.LoginAttempt{
+		UserID:    user.ID,
+		IP:        ratelimit.ClientIP(r, h.limits.TrustProxy),
+		UserAgent: truncate(r.UserAgent(), 512),
+		Success:   true,
+		Reason:    types.LoginPasswordReset,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reset password: recording event failed", "err", err)
+	}
+
+	if _, err := h.store.MarkEmailVerified(r.Context(), user.ID, user.Email, time.Now()); err != nil {
+		slog.ErrorContext(r.Context(), "reset password: verifying email failed", "err", err)
+	}
+}
+
+// handleChangePassword replaces the password of a logged in user who knows
+// the current one. Every other session is signed out and this one rotated.
+func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	withTokens, err := includeTokens(r)
+	if err != nil {
+		utils.WriteError(w, err)
+		return
+	}
+
+	var payload changePasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// a stolen session must not be a way to guess the password
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, fmt.Sprintf("password:user:%d", userID)) {
+		return
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "change password: loading user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if !auth.MatchPassword(&user.Password, &payload.CurrentPassword) {
+		slog.InfoContext(r.Context(), "change password: wrong current password")
+		utils.WriteError(w, types.Unauthorized("invalid_password", "current password is incorrect"))
+		return
+	}
+	if err := h.passwords.Check(payload.NewPassword, user.UserName, user.Email); err != nil {
+		slog.InfoContext(r.Context(), "password does not meet policy", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if auth.MatchPassword(&user.Password, &payload.NewPassword) {
+		utils.WriteError(w, types.Validation("password_unchanged", "new password must differ from the current one"))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(&payload.NewPassword)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "hashing password failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := h.store.UpdatePassword(r.Context(), userID, hashedPassword); err != nil {
+		slog.ErrorContext(r.Context(), "change password: updating password failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// sign out every session, including the one making the request, and
+	// hand this one fresh tokens
+	accessToken, err := auth.RotateSessions(r.Context(), h.revocations, h.refreshStore, userID, auth.TokenFromRequest(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "change password: revoking sessions failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	tokens, err := h.setSession(w, r, userID, accessToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "starting session failed", "err", err)
+		clearSessionCookies(w)
+		utils.WriteError(w, err)
+		return
+	}
+	if !withTokens {
+		tokens = nil
+	}
+	slog.InfoContext(r.Context(), "password changed")
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		*sessionTokens
+	}{
+		Success:       true,
+		Message:       "password changed, other devices have been logged out",
+		sessionTokens: tokens,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Waris-Shaik/todo/types"
+)
+
+// DeletionPolicy keeps a deleted account for Grace, during which logging in
+// restores it, before it is purged. Purges run every PurgeInterval; zero
+// disables them.
+type DeletionPolicy struct {
+	Grace         time.Duration
+	PurgeInterval time.Duration
+}
+
+// PurgeAt returns when an account deleted at deletedAt is purged.
+func (p DeletionPolicy) PurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(p.Grace)
+}
+
+// purgeBatchSize bounds how many accounts a single store call returns.
+const purgeBatchSize = 100
+
+// Purger removes accounts whose grace period is over, along with their todos.
+type Purger struct {
+	users  types.UserStore
+	todos  types.TodoStore
+	policy DeletionPolicy
+}
+
+func NewPurger(users types.UserStore, todos types.TodoStore, policy DeletionPolicy) *Purger {
+	return &Purger{users: users, todos: todos, policy: policy}
+}
+
+// Run purges once straight away and then every PurgeInterval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	if p.policy.PurgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.policy.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Purge(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "purging deleted accounts failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes every account deleted more than Grace ago and returns how
+// many it removed.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	before := time.Now().Add(-p.policy.Grace)
+
+	var purged int
+	for {
+		ids, err := p.users.GetUsersDeletedBefore(ctx, before, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, id := range ids {
+			// the user may have logged in and restored the account since it
+			// was listed, so only touch the todos once the user is gone
+			ok, err := p.users.PurgeUser(ctx, id, before)
+			if err != nil {
+				return purged, err
+			}
+			if !ok {
+				slog.InfoContext(ctx, "deleted account restored before purging", "purged_user_id", id)
+				continue
+			}
+			// the database cascades todos with the user, other stores need telling
+			todos, err := p.todos.DeleteUserTodos(ctx, id)
+			if err != nil {
+				return purged, err
+			}
+			slog.InfoContext(ctx, "purged deleted account", "purged_user_id", id, "todos", todos)
+			purged++
+		}
+
+		if len(ids) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
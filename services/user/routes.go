@@ -1,11 +1,18 @@
 package user
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Waris-Shaik/todo/metrics"
+	"github.com/Waris-Shaik/todo/passwordpolicy"
+	"github.com/Waris-Shaik/todo/ratelimit"
 	"github.com/Waris-Shaik/todo/services/auth"
 	"github.com/Waris-Shaik/todo/types"
 	"github.com/Waris-Shaik/todo/utils"
@@ -13,63 +20,136 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store        types.UserStore
+	todos        types.TodoStore
+	attempts     types.LoginAttemptStore
+	refreshStore types.RefreshTokenStore
+	resets       types.PasswordResetStore
+	revocations  types.TokenRevocationStore
+	accessTokens types.PersonalAccessTokenStore
+	identities   types.UserIdentityStore
+	mfaStore     types.MFAStore
+	limits       Limits
+	emails       Emails
+	deletion     DeletionPolicy
+	passwords    passwordpolicy.Policy
+	sso          SSO
+	twoFactor    TwoFactor
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+// Limits throttles login and registration. A nil limiter disables that limit.
+type Limits struct {
+	LoginIP ratelimit.Limiter
+	// LoginAccount is keyed by the email or username being logged in to
+	LoginAccount ratelimit.Limiter
+	RegisterIP   ratelimit.Limiter
+	// TrustProxy takes the client IP from X-Forwarded-For
+	TrustProxy bool
+	// Lockout locks accounts after repeated wrong passwords
+	Lockout LockoutPolicy
+	// VerificationEmail is keyed by user and limits resent verification emails
+	VerificationEmail ratelimit.Limiter
+	// PasswordForgotIP limits password reset emails per client IP
+	PasswordForgotIP ratelimit.Limiter
+}
+
+func NewHandler(store types.UserStore, todos types.TodoStore, attempts types.LoginAttemptStore, refreshStore types.RefreshTokenStore, resets types.PasswordResetStore, revocations types.TokenRevocationStore, accessTokens types.PersonalAccessTokenStore, identities types.UserIdentityStore, mfaStore types.MFAStore, limits Limits, emails Emails, deletion DeletionPolicy, passwords passwordpolicy.Policy, sso SSO, twoFactor TwoFactor) *Handler {
+	return &Handler{store: store, todos: todos, attempts: attempts, refreshStore: refreshStore, resets: resets, revocations: revocations, accessTokens: accessTokens, identities: identities, mfaStore: mfaStore, limits: limits, emails: emails, deletion: deletion, passwords: passwords, sso: sso, twoFactor: twoFactor}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/", h.handleRoot).Methods(http.MethodGet)
-	router.HandleFunc("/register", h.handleRegister).Methods(http.MethodPost)
-	router.HandleFunc("/login", h.handleLogin).Methods(http.MethodPost)
-	router.HandleFunc("/logout", h.handleLogout).Methods(http.MethodPost)
-	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleMyProfile, h.store)).Methods(http.MethodGet)
+	router.HandleFunc("/", h.handleRoot).Methods(http.MethodGet).Name("root")
+	router.HandleFunc("/register", ratelimit.Middleware(h.limits.RegisterIP, "register", h.limits.TrustProxy)(h.handleRegister)).Methods(http.MethodPost).Name("register")
+	router.HandleFunc("/login", ratelimit.Middleware(h.limits.LoginIP, "login", h.limits.TrustProxy)(h.handleLogin)).Methods(http.MethodPost).Name("login")
+	router.HandleFunc("/login/mfa", ratelimit.Middleware(h.limits.LoginIP, "login-mfa", h.limits.TrustProxy)(h.handleLoginMFA)).Methods(http.MethodPost).Name("login.mfa")
+	router.HandleFunc("/logout", h.handleLogout).Methods(http.MethodPost).Name("logout")
+	router.HandleFunc("/logout/all", auth.WithJWTAuth(h.handleLogoutAll, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("logout.all")
+	router.HandleFunc("/refresh", h.handleRefresh).Methods(http.MethodPost).Name("refresh")
+	router.HandleFunc("/oidc/login", ratelimit.Middleware(h.limits.LoginIP, "oidc-login", h.limits.TrustProxy)(h.handleSSOLogin)).Methods(http.MethodGet).Name("oidc.login")
+	router.HandleFunc("/oidc/callback", ratelimit.Middleware(h.limits.LoginIP, "oidc-callback", h.limits.TrustProxy)(h.handleSSOCallback)).Methods(http.MethodGet).Name("oidc.callback")
+	router.HandleFunc("/password/forgot", ratelimit.Middleware(h.limits.PasswordForgotIP, "password-forgot", h.limits.TrustProxy)(h.handleForgotPassword)).Methods(http.MethodPost).Name("password.forgot")
+	router.HandleFunc("/password/reset", ratelimit.Middleware(h.limits.PasswordForgotIP, "password-reset", h.limits.TrustProxy)(h.handleResetPassword)).Methods(http.MethodPost).Name("password.reset")
+	router.HandleFunc("/verify-email", h.handleVerifyEmail).Methods(http.MethodGet, http.MethodPost).Name("verify_email")
+	router.HandleFunc("/verify-email/resend", auth.WithJWTAuth(h.handleResendVerification, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("verify_email.resend")
+	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleMyProfile, h.store, h.revocations, h.accessTokens, auth.ScopeProfileRead)).Methods(http.MethodGet).Name("users.me")
+	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleUpdateProfile, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPatch).Name("users.me.update")
+	router.HandleFunc("/users/me", auth.WithJWTAuth(h.handleDeleteAccount, h.store, h.revocations, h.accessTokens)).Methods(http.MethodDelete).Name("users.me.delete")
+	router.HandleFunc("/users/me/export", auth.WithJWTAuth(h.handleExport, h.store, h.revocations, h.accessTokens)).Methods(http.MethodGet).Name("users.me.export")
+	router.HandleFunc("/users/me/password", auth.WithJWTAuth(h.handleChangePassword, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("users.me.password")
+	router.HandleFunc("/users/me/security-events", auth.WithJWTAuth(h.handleSecurityEvents, h.store, h.revocations, h.accessTokens)).Methods(http.MethodGet).Name("users.me.security_events")
+	router.HandleFunc("/users/me/tokens", auth.WithJWTAuth(h.handleListTokens, h.store, h.revocations, h.accessTokens)).Methods(http.MethodGet).Name("users.me.tokens")
+	router.HandleFunc("/users/me/tokens", auth.WithJWTAuth(h.handleCreateToken, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("users.me.tokens.create")
+	router.HandleFunc("/users/me/tokens/{id}", auth.WithJWTAuth(h.handleRevokeToken, h.store, h.revocations, h.accessTokens)).Methods(http.MethodDelete).Name("users.me.tokens.revoke")
+	router.HandleFunc("/users/me/mfa/totp", auth.WithJWTAuth(h.handleEnrollTOTP, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("users.me.mfa.enroll")
+	router.HandleFunc("/users/me/mfa/totp/confirm", auth.WithJWTAuth(h.handleConfirmTOTP, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("users.me.mfa.confirm")
+	router.HandleFunc("/users/me/mfa/totp", auth.WithJWTAuth(h.handleDisableTOTP, h.store, h.revocations, h.accessTokens)).Methods(http.MethodDelete).Name("users.me.mfa.disable")
+	router.HandleFunc("/users/me/mfa/recovery-codes", auth.WithJWTAuth(h.handleRegenerateRecoveryCodes, h.store, h.revocations, h.accessTokens)).Methods(http.MethodPost).Name("users.me.mfa.recovery_codes")
 
 }
 
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
+	withTokens, err := includeTokens(r)
+	if err != nil {
+		utils.WriteError(w, err)
+		return
+	}
+
 	// get the JSON payload from req.body and parse it
 	var payload types.RegisterUserPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
-		log.Println("Error in parsing PAYLOAD:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// validate the payload
-	if err := utils.ValidateRegisterUserPayload(&payload); err != nil {
-		log.Println("Error while validating payload:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
-	// match the password criteria
-	if err := utils.MatchPasswordCriteria(&payload.Password); err != nil {
-		log.Println("Error in matching password criteria", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+	// match the password policy
+	if err := h.passwords.Check(payload.Password, payload.UserName, payload.Email); err != nil {
+		slog.InfoContext(r.Context(), "password does not meet policy", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
-	// check the user if exsits in db
-	_, err := h.store.GetUserByEmail(payload.Email)
+	// check the email and username are free
+	_, err = h.store.GetUserByEmail(r.Context(), payload.Email)
+	if err == nil {
+		slog.InfoContext(r.Context(), "register: user already exists")
+		utils.WriteError(w, ErrEmailTaken)
+		return
+	}
+	if !errors.Is(err, types.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "register: looking up user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	_, err = h.store.GetUserByUsername(r.Context(), payload.UserName)
 	if err == nil {
-		log.Println("User with these credentials already exists")
-		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user already exists, please login"))
+		slog.InfoContext(r.Context(), "register: username taken")
+		utils.WriteError(w, ErrUsernameTaken)
+		return
+	}
+	if !errors.Is(err, types.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "register: looking up user failed", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// hash the password
 	hashedPassword, err := auth.HashPassword(&payload.Password)
 	if err != nil {
-		log.Println("Error hashing password:", err)
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		slog.ErrorContext(r.Context(), "hashing password failed", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// create a new user
-	userID, err := h.store.CreateUser(types.User{
+	userID, err := h.store.CreateUser(r.Context(), types.User{
 		FirstName: payload.FirstName,
 		LastName:  payload.LastName,
 		UserName:  payload.UserName,
@@ -78,44 +158,36 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		log.Println("Error while creating a user:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.ErrorContext(r.Context(), "register: creating user failed", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
-	// get the cookie
-	token, err := auth.CreateJWT(userID)
-	if err != nil {
-		log.Println("Error in generating JWT token:", err)
-		utils.WriteError(w, http.StatusInternalServerError, err)
-		return
+	// the account works without it, so a mail failure does not fail registration
+	if err := h.sendVerificationEmail(r.Context(), userID, payload.FirstName, payload.Email); err != nil {
+		slog.ErrorContext(r.Context(), "register: sending verification email failed", "err", err)
 	}
 
-	// set the JWT as cookie
-	cookie := &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		HttpOnly: true,
-		Path:     "/",
-		Expires:  time.Now().Add(15 * time.Minute),
-		SameSite: http.SameSiteNoneMode,
-		Secure:   true,
+	// start the session
+	tokens, err := h.startSession(w, r, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "starting session failed", "err", err)
+		utils.WriteError(w, err)
+		return
 	}
-
-	if utils.GetNodeENV("NODE_ENV") == "Development" {
-		cookie.SameSite = http.SameSiteLaxMode
-		cookie.Secure = false
+	if !withTokens {
+		tokens = nil
 	}
 
-	http.SetCookie(w, cookie)
-
 	// return the response
 	reponse := struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
+		*sessionTokens
 	}{
-		Success: true,
-		Message: "user created successfully",
+		Success:       true,
+		Message:       "user created successfully, please check your inbox to verify your email",
+		sessionTokens: tokens,
 	}
 
 	utils.WriteJSON(w, http.StatusCreated, reponse)
@@ -123,71 +195,167 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	withTokens, err := includeTokens(r)
+	if err != nil {
+		utils.WriteError(w, err)
+		return
+	}
+
 	// get the JSON payload from req.body and parse it
 	var payload types.LoginUserPayload
 	if err := utils.ParseJSON(r, &payload); err != nil {
-		log.Println("Error in parsing PAYLOAD:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// validate the payload
-	if err := utils.ValidateLoginUserPayload(&payload); err != nil {
-		log.Println("Error occured in validated payload:", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// throttle guesses against a single account, whichever IPs they come from
+	if !ratelimit.Check(w, r, h.limits.LoginAccount, "login:account:"+strings.ToLower(strings.TrimSpace(payload.Text))) {
 		return
 	}
 
-	// check the user if exsits in db
-	user, err := h.store.GetUserByEmail(payload.Text)
+	// check the user if exsits in db, deleted accounts only until their grace period is over
+	user, err := h.store.GetUserByLogin(r.Context(), payload.Text)
+	if err == nil && user.DeletedAt != nil && !time.Now().Before(h.deletion.PurgeAt(*user.DeletedAt)) {
+		err = types.NotFound("user_not_found", "user not found")
+	}
+	if errors.Is(err, types.ErrNotFound) {
+		slog.InfoContext(r.Context(), "login: user not found")
+		h.recordLoginAttempt(r, 0, payload.Text, types.LoginUserNotFound)
+		utils.WriteError(w, types.NotFound("user_not_found", "user not found, please register"))
+		return
+	}
 	if err != nil {
-		log.Println("Error user not found, please register", err)
-		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found, please register"))
+		slog.ErrorContext(r.Context(), "login: looking up user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// refuse locked accounts before looking at the password
+	if !h.checkLockout(w, r, user, payload.Text) {
 		return
 	}
 
 	// check the password matches or does not
 	if !auth.MatchPassword(&user.Password, &payload.Password) {
-		log.Println("Error password does not match:", !auth.MatchPassword(&user.Password, &payload.Password))
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid passsword"))
+		slog.InfoContext(r.Context(), "login: wrong password", "login_user_id", user.ID)
+		h.recordLoginAttempt(r, user.ID, payload.Text, types.LoginInvalidPassword)
+		utils.WriteError(w, types.Unauthorized("invalid_password", "invalid password"))
 		return
 	}
 
-	// set the JWT token
-	jwtToken, err := auth.CreateJWT(user.ID)
-	if err != nil {
-		log.Println("Error in generating JWT token:", err)
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	// with two-factor authentication on, the password only earns a token for
+	// the second step at /login/mfa
+	if user.TOTPEnabledAt != nil {
+		h.startMFALogin(w, r, user)
 		return
 	}
-	// set the JWT as cookie
-	cookie := &http.Cookie{
-		Name:     "token",
-		Value:    jwtToken,
-		HttpOnly: true,
-		Path:     "/",
-		Expires:  time.Now().Add(15 * time.Minute),
-		SameSite: http.SameSiteNoneMode,
-		Secure:   true,
+
+	h.completeLogin(w, r, user, payload.Text, withTokens, "")
+}
+
+// checkLockout answers for accounts locked by repeated wrong passwords or
+// codes and reports whether the login may go on.
+func (h *Handler) checkLockout(w http.ResponseWriter, r *http.Request, user *types.User, identifier string) bool {
+	failures, lastFailure, err := h.attempts.GetLoginFailures(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "login: counting failures failed", "err", err)
+		utils.WriteError(w, err)
+		return false
 	}
-	if utils.GetNodeENV("NODE_ENV") == "Development" {
-		cookie.SameSite = http.SameSiteLaxMode
-		cookie.Secure = false
+	if lockedUntil := h.limits.Lockout.LockedUntil(failures, lastFailure); time.Now().Before(lockedUntil) {
+		slog.WarnContext(r.Context(), "login: account locked", "login_user_id", user.ID, "failures", failures, "locked_until", lockedUntil)
+		h.recordLoginAttempt(r, user.ID, identifier, types.LoginAccountLocked)
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+		utils.WriteError(w, types.TooManyRequests("account_locked", "too many failed login attempts, please try again later"))
+		return false
 	}
+	return true
+}
 
-	http.SetCookie(w, cookie)
+// completeLogin starts the session of a user who proved who they are and
+// answers the login. A non-empty note is added to the welcome message.
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *types.User, identifier string, withTokens bool, note string) {
+	// logging in to an account scheduled for deletion keeps it
+	message := fmt.Sprintf("welcome back %v", user.UserName)
+	if user.DeletedAt != nil {
+		if err := h.store.RestoreUser(r.Context(), user.ID); err != nil {
+			slog.ErrorContext(r.Context(), "login: restoring account failed", "err", err)
+			utils.WriteError(w, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "login: account deletion cancelled", "login_user_id", user.ID)
+		message += ", your account is no longer scheduled for deletion"
+	}
+	if note != "" {
+		message += ", " + note
+	}
+
+	// start the session
+	tokens, err := h.startSession(w, r, user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "starting session failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if !withTokens {
+		tokens = nil
+	}
 
 	// return the response
 	response := struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
+		*sessionTokens
 	}{
-		Success: true,
-		Message: fmt.Sprintf("welcome back %v", user.UserName),
+		Success:       true,
+		Message:       message,
+		sessionTokens: tokens,
 	}
 
+	h.recordLoginAttempt(r, user.ID, identifier, "")
+
 	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// recordLoginAttempt adds an attempt to the audit trail; an empty reason
+// means it succeeded. Failing to record does not fail the login.
+func (h *Handler) recordLoginAttempt(r *http.Request, userID int, identifier, reason string) {
+	if reason == "" {
+		metrics.LoginSucceeded()
+	} else {
+		metrics.LoginFailed()
+	}
+
+	err := h.attempts.RecordLoginAttempt(r.Context(), types.LoginAttempt{
+		UserID:     userID,
+		Identifier: truncate(identifier, 255),
+		IP:         ratelimit.ClientIP(r, h.limits.TrustProxy),
+		UserAgent:  truncate(r.UserAgent(), 512),
+		Success:    reason == "",
+		Reason:     reason,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "login: recording attempt failed", "err", err)
+	}
+}
 
+// truncate cuts s to at most max bytes without splitting a character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
 }
 
 func (h *Handler) handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -203,14 +371,22 @@ func (h *Handler) handleRoot(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
-	// clear the JWT token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		HttpOnly: true,
-		Path:     "/",
-		Expires:  time.Unix(0, 0),
-	})
+	// revoke the refresh token family so it cannot mint new access tokens
+	if refreshToken := refreshTokenFromRequest(r); refreshToken != "" {
+		if err := auth.RevokeRefreshToken(r.Context(), h.refreshStore, refreshToken); err != nil {
+			slog.WarnContext(r.Context(), "logout: revoking refresh token failed", "err", err)
+		}
+	}
+
+	// revoke the access token so a copied JWT stops working as well
+	if accessToken := auth.TokenFromRequest(r); accessToken != "" {
+		if err := auth.RevokeJWT(r.Context(), h.revocations, accessToken); err != nil {
+			slog.WarnContext(r.Context(), "logout: revoking access token failed", "err", err)
+		}
+	}
+
+	clearSessionCookies(w)
+
 	response := struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
@@ -222,29 +398,297 @@ func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, response)
 }
 
+func (h *Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	// revoke every access and refresh token issued to the user
+	if err := auth.RevokeAllSessions(r.Context(), h.revocations, h.refreshStore, userID); err != nil {
+		slog.ErrorContext(r.Context(), "revoking sessions failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	clearSessionCookies(w)
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "logged out of all devices",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	withTokens, err := includeTokens(r)
+	if err != nil {
+		utils.WriteError(w, err)
+		return
+	}
+
+	usedToken := refreshTokenFromRequest(r)
+	if usedToken == "" {
+		slog.InfoContext(r.Context(), "refresh: token missing")
+		utils.WriteError(w, types.Unauthorized("invalid_refresh_token", "please login"))
+		return
+	}
+
+	// exchange the refresh token for a new one in the same family
+	userID, refreshToken, err := auth.RotateRefreshToken(r.Context(), h.refreshStore, usedToken)
+	if err != nil {
+		slog.InfoContext(r.Context(), "refresh: rotating token failed", "err", err)
+		if errors.Is(err, types.ErrUnauthorized) {
+			clearSessionCookies(w)
+		}
+		utils.WriteError(w, err)
+		return
+	}
+
+	accessToken, err := auth.CreateJWT(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "refresh: creating JWT failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	tokens := setSessionCookies(w, accessToken, refreshToken)
+	if !withTokens {
+		tokens = nil
+	}
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		*sessionTokens
+	}{
+		Success:       true,
+		Message:       "session refreshed",
+		sessionTokens: tokens,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
 func (h *Handler) handleMyProfile(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 	if userID == -1 {
-		log.Println("Something went wrong", userID)
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("something went wrong"))
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading profile failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// return the response
+	response := struct {
+		Success bool        `json:"success"`
+		User    *types.User `json:"user"`
+	}{
+		Success: true,
+		User:    user,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	// get the JSON payload from req.body and parse it
+	var payload types.UserUpdatePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// validate the payload
+	if payload.FirstName == nil && payload.LastName == nil && payload.UserName == nil {
+		slog.InfoContext(r.Context(), "empty update payload")
+		utils.WriteError(w, types.Validation("validation_failed", "at least one of first_name, last_name or username is required"))
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
-	user, err := h.store.GetUserByID(userID)
+	// apply the update, which also checks the username is free
+	user, err := h.store.UpdateUser(r.Context(), userID, payload)
 	if err != nil {
-		log.Println("Error while retreiving user from db", err)
-		utils.WriteError(w, http.StatusBadRequest, err)
+		slog.InfoContext(r.Context(), "updating profile failed", "err", err)
+		utils.WriteError(w, err)
 		return
 	}
 
 	// return the response
 	response := struct {
 		Success bool        `json:"success"`
+		Message string      `json:"message"`
 		User    *types.User `json:"user"`
 	}{
 		Success: true,
+		Message: "profile updated successfully",
 		User:    user,
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
 }
+
+func (h *Handler) handleSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			utils.WriteError(w, types.Validation("invalid_query", "limit must be between 1 and 100"))
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := h.attempts.GetLoginAttempts(r.Context(), userID, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading security events failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	// return the response
+	response := struct {
+		Success bool                  `json:"success"`
+		Events  []*types.LoginAttempt `json:"events"`
+	}{
+		Success: true,
+		Events:  events,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+const (
+	accessCookieName  = "token"
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/api/v1"
+)
+
+// sessionTokens are a session's tokens as returned in the response body to
+// clients that cannot keep cookies and ask for them with ?include_token=true.
+type sessionTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// includeTokens reports whether the client asked for the session's tokens in
+// the response body.
+func includeTokens(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("include_token")
+	if value == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, types.Validation("invalid_query", "include_token must be true or false")
+	}
+	return include, nil
+}
+
+// startSession issues a short-lived access token and a new refresh token family
+// for userID and sets both as cookies.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID int) (*sessionTokens, error) {
+	accessToken, err := auth.CreateJWT(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.setSession(w, r, userID, accessToken)
+}
+
+// setSession starts a new refresh token family for userID and sets it as a
+// cookie along with accessToken.
+func (h *Handler) setSession(w http.ResponseWriter, r *http.Request, userID int, accessToken string) (*sessionTokens, error) {
+	refreshToken, err := auth.IssueRefreshToken(r.Context(), h.refreshStore, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return setSessionCookies(w, accessToken, refreshToken), nil
+}
+
+func setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) *sessionTokens {
+	setCookie(w, accessCookieName, accessToken, "/", time.Now().Add(auth.AccessTokenTTL))
+	setCookie(w, refreshCookieName, refreshToken, refreshCookiePath, time.Now().Add(auth.RefreshTokenTTL))
+
+	return &sessionTokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}
+}
+
+// refreshTokenFromRequest returns the refresh token from its cookie or, for
+// clients without cookies, from a {"refresh_token": "..."} body.
+func refreshTokenFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(refreshCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return ""
+	}
+	return payload.RefreshToken
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	setCookie(w, accessCookieName, "", "/", time.Unix(0, 0))
+	setCookie(w, refreshCookieName, "", refreshCookiePath, time.Unix(0, 0))
+}
+
+func setCookie(w http.ResponseWriter, name, value, path string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		Path:     path,
+		Expires:  expires,
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+	}
+
+	if utils.GetNodeENV("NODE_ENV") == "Development" {
+		cookie.SameSite = http.SameSiteLaxMode
+		cookie.Secure = false
+	}
+
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,54 @@
+package user_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Waris-Shaik/todo/services/user"
+)
+
+func TestLoginRightAfterLogoutAll(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	old := s.register()
+
+	if w, response := s.do(http.MethodPost, "/logout/all", nil, old); w.Code != http.StatusOK {
+		t.Fatalf("logout all answered %d: %v", w.Code, response)
+	}
+	// logging straight back in lands within the second of the revocation
+	w, response := s.login(testPassword)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login answered %d: %v", w.Code, response)
+	}
+
+	if w, response := s.do(http.MethodGet, "/users/me", nil, response["access_token"].(string)); w.Code != http.StatusOK {
+		t.Fatalf("token from the login after logging out everywhere answered %d: %v", w.Code, response)
+	}
+	if w, _ := s.do(http.MethodGet, "/users/me", nil, old); w.Code != http.StatusUnauthorized {
+		t.Fatalf("token from before logging out everywhere answered %d, want 401", w.Code)
+	}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	s := newTestServer(t, user.Limits{})
+	current := s.register()
+	// another device logged in within the same second, say with a stolen password
+	_, response := s.login(testPassword)
+	other := response["access_token"].(string)
+
+	w, response := s.do(http.MethodPost, "/users/me/password?include_token=true", map[string]string{
+		"current_password": testPassword,
+		"new_password":     "garden-cloud-ferry",
+	}, current)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password answered %d: %v", w.Code, response)
+	}
+
+	if w, response := s.do(http.MethodGet, "/users/me", nil, response["access_token"].(string)); w.Code != http.StatusOK {
+		t.Fatalf("token from the password change answered %d: %v", w.Code, response)
+	}
+	for name, token := range map[string]string{"caller's old": current, "other device's": other} {
+		if w, _ := s.do(http.MethodGet, "/users/me", nil, token); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s token answered %d after the password change, want 401", name, w.Code)
+		}
+	}
+}
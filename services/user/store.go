@@ -1,13 +1,33 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/Waris-Shaik/todo/db"
 	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
 )
 
+// Errors for a username or email that already belongs to another user.
+var (
+	ErrUsernameTaken = types.FieldConflict("username_taken", "username", "username is already taken")
+	ErrEmailTaken    = types.FieldConflict("user_already_exists", "email", "user already exists, please login")
+)
+
+// duplicateUserError tells which unique index a duplicate key error hit. Both
+// MySQL and SQLite name the index or column in the message.
+func duplicateUserError(err error) error {
+	if strings.Contains(err.Error(), "username") {
+		return ErrUsernameTaken
+	}
+	return ErrEmailTaken
+}
+
 type Store struct {
 	db *sql.DB
 }
@@ -16,26 +36,50 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) GetUserByEmail(email string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM user WHERE email = ? OR username = ?", email, email)
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	return s.getUser(ctx, "SELECT * FROM user WHERE email = ?", utils.NormalizeEmail(email))
+}
+
+// GetUserByUsername matches LOWER(username) so the unique index on it is used.
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*types.User, error) {
+	return s.getUser(ctx, "SELECT * FROM user WHERE LOWER(username) = ?", strings.ToLower(username))
+}
+
+func (s *Store) GetUserByLogin(ctx context.Context, login string) (*types.User, error) {
+	if strings.Contains(login, "@") {
+		return s.GetUserByEmail(ctx, login)
+	}
+	return s.GetUserByUsername(ctx, login)
+}
+
+// getUser returns the single user matched by query.
+func (s *Store) getUser(ctx context.Context, query string, args ...any) (*types.User, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return nil, fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
 	}
+	defer rows.Close()
 
 	user := new(types.User)
 
 	for rows.Next() {
 		user, err = scanRowIntoUser(rows)
 		if err != nil {
-			log.Println("Error in rows.Next()", err)
-			return nil, err
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
 
 	if user.ID == 0 {
-		log.Println("User not found:", user)
-		return nil, fmt.Errorf("user not found")
+		return nil, types.NotFound("user_not_found", "user not found")
 	}
 
 	return user, nil
@@ -53,51 +97,227 @@ func scanRowIntoUser(rows *sql.Rows) (*types.User, error) {
 		&user.Password,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.EmailVerifiedAt,
+		&user.DeletedAt,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
+		&user.TOTPLastStep,
 	)
 
 	if err != nil {
-		log.Println("Error in scanningRowIntoUser:", err)
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *Store) GetUserByID(id int) (*types.User, error) {
-	rows, err := s.db.Query("SELECT * FROM user WHERE id = ?", id)
+func (s *Store) GetUserByID(ctx context.Context, id int) (*types.User, error) {
+	return s.getUser(ctx, "SELECT * FROM user WHERE id = ?", id)
+}
+
+func (s *Store) CreateUser(ctx context.Context, user types.User) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "INSERT INTO user (first_name, last_name, username, email, password) VALUES (?,?,?,?,?)", user.FirstName, user.LastName, user.UserName, utils.NormalizeEmail(user.Email), user.Password)
+	if db.IsDuplicateKey(err) {
+		return 0, duplicateUserError(err)
+	}
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return nil, err
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading last insert ID failed", "err", err)
+		return 0, db.Error(ctx, err)
 	}
+	slog.InfoContext(ctx, "user created", "created_user_id", id)
+	return int(id), nil
+}
 
-	user := new(types.User)
+func (s *Store) MarkEmailVerified(ctx context.Context, id int, email string, at time.Time) (bool, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
 
+	result, err := s.db.ExecContext(ctx, "UPDATE user SET email_verified_at = ? WHERE id = ? AND email = ? AND email_verified_at IS NULL", at.UTC(), id, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	return affected == 1, nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE user SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if affected == 0 {
+		return types.NotFound("user_not_found", "user not found")
+	}
+	return nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, id int, payload types.UserUpdatePayload) (*types.User, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	setClauses := make([]string, 0, 3)
+	args := make([]any, 0, 4)
+
+	if payload.FirstName != nil {
+		setClauses = append(setClauses, "first_name = ?")
+		args = append(args, *payload.FirstName)
+	}
+	if payload.LastName != nil {
+		setClauses = append(setClauses, "last_name = ?")
+		args = append(args, *payload.LastName)
+	}
+	if payload.UserName != nil {
+		setClauses = append(setClauses, "username = ?")
+		args = append(args, *payload.UserName)
+	}
+	if len(setClauses) == 0 {
+		return nil, types.Validation("validation_failed", "nothing to update")
+	}
+	args = append(args, id)
+
+	// apply the update and read back the row in a single transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE user SET %s WHERE id = ?", strings.Join(setClauses, ", "))
+	_, err = tx.ExecContext(ctx, query, args...)
+	if db.IsDuplicateKey(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM user WHERE id = ?", id)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer rows.Close()
+
+	user := new(types.User)
 	for rows.Next() {
 		user, err = scanRowIntoUser(rows)
 		if err != nil {
-			log.Println("Error in rows.Next()", err)
-			return nil, err
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
 		}
 	}
-
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
 	if user.ID == 0 {
-		log.Println("User not found:", user)
-		return nil, fmt.Errorf("user not found")
+		return nil, types.NotFound("user_not_found", "user not found")
 	}
+	rows.Close()
 
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
 	return user, nil
 }
 
-func (s *Store) CreateUser(user types.User) (int, error) {
-	result, err := s.db.Exec("INSERT INTO user (first_name, last_name, username, email, password) VALUES (?,?,?,?,?)", user.FirstName, user.LastName, user.UserName, user.Email, user.Password)
+func (s *Store) DeleteUser(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE user SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", at.UTC(), id)
 	if err != nil {
-		log.Println("Error in QUERY:", err)
-		return 0, fmt.Errorf("something went wrong")
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
 	}
-	id, err := result.LastInsertId()
+	affected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v\n", err)
-		return 0, err
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return db.Error(ctx, err)
 	}
-	log.Printf("User created with ID: %d", id)
-	return int(id), nil
+	if affected == 0 {
+		return types.NotFound("user_not_found", "user not found")
+	}
+	return nil
+}
+
+func (s *Store) RestoreUser(ctx context.Context, id int) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE user SET deleted_at = NULL WHERE id = ?", id); err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) GetUsersDeletedBefore(ctx context.Context, before time.Time, limit int) ([]int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM user WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id LIMIT ?", before.UTC(), limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			slog.ErrorContext(ctx, "scanning row failed", "err", err)
+			return nil, db.Error(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "iterating rows failed", "err", err)
+		return nil, db.Error(ctx, err)
+	}
+	return ids, nil
+}
+
+// PurgeUser deletes the user row; todos, tokens and login attempts go with it
+// through their cascading foreign keys.
+func (s *Store) PurgeUser(ctx context.Context, id int, deletedBefore time.Time) (bool, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM user WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", id, deletedBefore.UTC())
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	return affected > 0, nil
 }
@@ -0,0 +1,54 @@
+package user_test
+
+import (
+	"testing"
+
+	"github.com/Waris-Shaik/todo/services/storetest"
+	"github.com/Waris-Shaik/todo/services/user"
+	"github.com/Waris-Shaik/todo/types"
+)
+
+func TestMemoryStore(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) store { return user.NewMemoryStore() })
+}
+
+func TestSQLiteStore(t *testing.T) {
+	runStoreTests(t, func(t *testing.T) store { return user.NewStore(storetest.SQLite(t)) })
+}
+
+func TestMySQLStore(t *testing.T) {
+	storetest.SkipWithoutMySQL(t)
+	runStoreTests(t, func(t *testing.T) store { return user.NewStore(storetest.MySQL(t)) })
+}
+
+// store is implemented by both user stores.
+type store interface {
+	types.UserStore
+	types.LoginAttemptStore
+	types.UserIdentityStore
+	types.MFAStore
+}
+
+func runStoreTests(t *testing.T, newStore func(t *testing.T) store) {
+	t.Run("Users", func(t *testing.T) {
+		storetest.RunUserStoreTests(t, func(t *testing.T) types.UserStore { return newStore(t) })
+	})
+	t.Run("LoginAttempts", func(t *testing.T) {
+		storetest.RunLoginAttemptStoreTests(t, func(t *testing.T) (types.LoginAttemptStore, types.UserStore) {
+			s := newStore(t)
+			return s, s
+		})
+	})
+	t.Run("Identities", func(t *testing.T) {
+		storetest.RunIdentityStoreTests(t, func(t *testing.T) (types.UserIdentityStore, types.UserStore) {
+			s := newStore(t)
+			return s, s
+		})
+	})
+	t.Run("MFA", func(t *testing.T) {
+		storetest.RunMFAStoreTests(t, func(t *testing.T) (types.MFAStore, types.UserStore) {
+			s := newStore(t)
+			return s, s
+		})
+	})
+}
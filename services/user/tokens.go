@@ -0,0 +1,137 @@
+package user
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+	"github.com/gorilla/mux"
+)
+
+// handleCreateToken creates a personal access token for scripts. The raw
+// token is only ever returned here.
+func (h *Handler) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	var payload types.PersonalAccessTokenPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		slog.InfoContext(r.Context(), "invalid payload", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if err := utils.Validate(&payload); err != nil {
+		slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if payload.ExpiresInDays > 0 {
+		at := time.Now().UTC().Truncate(time.Second).AddDate(0, 0, payload.ExpiresInDays)
+		expiresAt = &at
+	}
+
+	raw, token, err := auth.IssuePersonalAccessToken(r.Context(), h.accessTokens, userID, payload.Name, uniqueScopes(payload.Scopes), expiresAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating personal access token failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "personal access token created", "token_id", token.ID, "scopes", token.Scopes)
+
+	response := struct {
+		Success bool                       `json:"success"`
+		Message string                     `json:"message"`
+		Token   string                     `json:"token"`
+		Details *types.PersonalAccessToken `json:"details"`
+	}{
+		Success: true,
+		Message: "token created, copy it now as it will not be shown again",
+		Token:   raw,
+		Details: token,
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, response)
+}
+
+func (h *Handler) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	tokens, err := h.accessTokens.GetPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading personal access tokens failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+
+	response := struct {
+		Success bool                         `json:"success"`
+		Tokens  []*types.PersonalAccessToken `json:"tokens"`
+	}{
+		Success: true,
+		Tokens:  tokens,
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		slog.InfoContext(r.Context(), "invalid token ID", "err", err)
+		utils.WriteError(w, types.Validation("invalid_token_id", "token ID must be an integer"))
+		return
+	}
+
+	if err := h.accessTokens.RevokePersonalAccessToken(r.Context(), userID, tokenID, time.Now()); err != nil {
+		slog.InfoContext(r.Context(), "revoking personal access token failed", "token_id", tokenID, "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "personal access token revoked", "token_id", tokenID)
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "token revoked",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// uniqueScopes drops repeated scopes, keeping the first of each.
+func uniqueScopes(scopes []string) []string {
+	seen := make(map[string]bool, len(scopes))
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,173 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/Waris-Shaik/todo/db"
+)
+
+func (s *Store) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "UPDATE user SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", secret, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) EnableTOTP(ctx context.Context, userID int, step int64, at time.Time, recoveryCodeHashes []string) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE user SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ? AND totp_secret <> '' AND totp_enabled_at IS NULL",
+		at.UTC(), step, userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if affected == 0 {
+		return ErrMFANotEnrolled
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+func (s *Store) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE user SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+// UseTOTPStep only moves the last step forward, so of two requests racing
+// with the same code just one succeeds.
+func (s *Store) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE user SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	return affected == 1, nil
+}
+
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "begin transaction failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "commit failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores hashes in
+// their place.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_code WHERE user_id = ?", userID); err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return db.Error(ctx, err)
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_code (user_id, code_hash) VALUES (?,?)", userID, hash); err != nil {
+			slog.ErrorContext(ctx, "exec failed", "err", err)
+			return db.Error(ctx, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) (bool, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE mfa_recovery_code SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", at.UTC(), userID, hash)
+	if err != nil {
+		slog.ErrorContext(ctx, "exec failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading rows affected failed", "err", err)
+		return false, db.Error(ctx, err)
+	}
+	return affected > 0, nil
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := db.WithTimeout(ctx)
+	defer cancel()
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM mfa_recovery_code WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count); err != nil {
+		slog.ErrorContext(ctx, "query failed", "err", err)
+		return 0, db.Error(ctx, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Waris-Shaik/todo/mailer"
+	"github.com/Waris-Shaik/todo/ratelimit"
+	"github.com/Waris-Shaik/todo/services/auth"
+	"github.com/Waris-Shaik/todo/types"
+	"github.com/Waris-Shaik/todo/utils"
+)
+
+// Emails configures the mail the handler sends.
+type Emails struct {
+	Mailer mailer.Mailer
+	// BaseURL is where links in emails point, e.g. https://todo.example.com
+	BaseURL string
+	// PasswordResetURL is the page that takes the token from a reset email
+	// and posts it with the new password
+	PasswordResetURL string
+}
+
+func (h *Handler) sendVerificationEmail(ctx context.Context, userID int, firstName, email string) error {
+	token, err := auth.CreateEmailVerificationToken(userID, email)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/verify-email?token=%s", h.emails.BaseURL, url.QueryEscape(token))
+	return h.emails.Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n\nIf you did not sign up, you can ignore this email.\n",
+			firstName, int(auth.EmailVerificationTTL.Hours()), link),
+	})
+}
+
+// handleVerifyEmail confirms the address a verification token was sent to.
+// The token comes from the query string when the link is opened directly, or
+// from a JSON body when a frontend posts it.
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var payload struct {
+			Token string `json:"token" validate:"required"`
+		}
+		if err := utils.ParseJSON(r, &payload); err != nil {
+			slog.InfoContext(r.Context(), "invalid payload", "err", err)
+			utils.WriteError(w, err)
+			return
+		}
+		if err := utils.Validate(&payload); err != nil {
+			slog.InfoContext(r.Context(), "payload failed validation", "err", err)
+			utils.WriteError(w, err)
+			return
+		}
+		token = payload.Token
+	}
+
+	userID, err := auth.ParseEmailVerificationToken(token)
+	if err != nil {
+		slog.InfoContext(r.Context(), "verify email: invalid token")
+		utils.WriteError(w, err)
+		return
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.InfoContext(r.Context(), "verify email: loading user failed", "err", err)
+		if errors.Is(err, types.ErrNotFound) {
+			err = auth.ErrVerificationTokenInvalid
+		}
+		utils.WriteError(w, err)
+		return
+	}
+	if !auth.CheckEmailVerificationToken(token, user.Email) {
+		slog.WarnContext(r.Context(), "verify email: bad signature", "token_user_id", userID)
+		utils.WriteError(w, auth.ErrVerificationTokenInvalid)
+		return
+	}
+
+	verified, err := h.store.MarkEmailVerified(r.Context(), user.ID, user.Email, time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verify email: marking verified failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if !verified {
+		// tokens are single use: once the address is verified every token is spent
+		utils.WriteError(w, types.Conflict("email_already_verified", "email is already verified"))
+		return
+	}
+	slog.InfoContext(r.Context(), "email verified", "verified_user_id", user.ID)
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "email verified successfully",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+	if userID == -1 {
+		slog.ErrorContext(r.Context(), "no user ID in request context")
+		utils.WriteError(w, types.Internal(fmt.Errorf("no user ID in request context")))
+		return
+	}
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resend verification: loading user failed", "err", err)
+		utils.WriteError(w, err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		utils.WriteError(w, types.Conflict("email_already_verified", "email is already verified"))
+		return
+	}
+
+	if !ratelimit.Check(w, r, h.limits.VerificationEmail, "verification-email:"+strconv.Itoa(user.ID)) {
+		return
+	}
+
+	if err := h.sendVerificationEmail(r.Context(), user.ID, user.FirstName, user.Email); err != nil {
+		slog.ErrorContext(r.Context(), "resend verification: sending email failed", "err", err)
+		utils.WriteError(w, types.Internal(err))
+		return
+	}
+
+	response := struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{
+		Success: true,
+		Message: "verification email sent",
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
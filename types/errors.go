@@ -0,0 +1,99 @@
+package types
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error kinds. Stores and handlers return an *Error wrapping one of these, and
+// utils.WriteError picks the HTTP status from the kind.
+var (
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("too many requests")
+	ErrInternal     = errors.New("internal error")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrTimeout      = errors.New("timeout")
+)
+
+// Error is an error with a kind, a stable machine-readable code that clients
+// can switch on, and a message that is safe to show them. Cause is only logged.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Details []FieldError
+	Cause   error
+}
+
+// FieldError describes one invalid field of a request payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Cause}
+}
+
+func Validation(code, message string) *Error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message}
+}
+
+// InvalidFields is a validation error listing every failing field.
+func InvalidFields(details []FieldError) *Error {
+	messages := make([]string, 0, len(details))
+	for _, detail := range details {
+		messages = append(messages, detail.Message)
+	}
+	return &Error{
+		Kind:    ErrValidation,
+		Code:    "validation_failed",
+		Message: strings.Join(messages, "; "),
+		Details: details,
+	}
+}
+
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: ErrUnauthorized, Code: code, Message: message}
+}
+
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: message}
+}
+
+func NotFound(code, message string) *Error {
+	return &Error{Kind: ErrNotFound, Code: code, Message: message}
+}
+
+func Conflict(code, message string) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Message: message}
+}
+
+// FieldConflict is a conflict on a field that must be unique, reported in
+// Details so clients can point at it.
+func FieldConflict(code, field, message string) *Error {
+	return &Error{
+		Kind:    ErrConflict,
+		Code:    code,
+		Message: message,
+		Details: []FieldError{{Field: field, Rule: "unique", Message: message}},
+	}
+}
+
+func TooManyRequests(code, message string) *Error {
+	return &Error{Kind: ErrRateLimited, Code: code, Message: message}
+}
+
+// Internal hides cause behind a generic message.
+func Internal(cause error) *Error {
+	return &Error{Kind: ErrInternal, Code: "internal", Message: "something went wrong", Cause: cause}
+}
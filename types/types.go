@@ -1,13 +1,18 @@
 package types
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type TodoStore interface {
-	CreateTodo(Todo) error
-	GetTodos(id int) ([]*Todo, error)
-	GetTodoByID(id int) (*Todo, error)
-	UpdateTodo(id int) error
-	DeleteTodo(id int) error
+	CreateTodo(ctx context.Context, todo Todo) error
+	GetTodos(ctx context.Context, id int, query TodoQuery) (*TodoPage, error)
+	GetTodoByID(ctx context.Context, id int) (*Todo, error)
+	UpdateTodo(ctx context.Context, id int, payload TodoUpdatePayload) (*Todo, error)
+	DeleteTodo(ctx context.Context, id int) error
+	// DeleteUserTodos deletes every todo of the user and returns how many there were.
+	DeleteUserTodos(ctx context.Context, userID int) (int, error)
 }
 
 type TodoPayload struct {
@@ -15,6 +20,39 @@ type TodoPayload struct {
 	Description string `json:"description"`
 }
 
+// TodoUpdatePayload holds the fields of a partial todo update; nil fields are left untouched.
+type TodoUpdatePayload struct {
+	Title       *string `json:"title" validate:"omitempty,min=1"`
+	Description *string `json:"description"`
+	Status      *string `json:"status" validate:"omitempty,oneof=pending completed"`
+}
+
+// TodoQuery describes the filters, ordering and pagination applied when listing todos.
+type TodoQuery struct {
+	Status        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Search        string
+	SortBy        string
+	SortDesc      bool
+	Limit         int
+	Offset        int
+	Cursor        *TodoCursor
+}
+
+// TodoCursor points just past the last todo of a page for keyset pagination.
+type TodoCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// TodoPage is a single page of todos together with the total number of matches.
+type TodoPage struct {
+	Todos      []*Todo
+	Total      int
+	NextCursor *TodoCursor
+}
+
 type Todo struct {
 	ID          int       `json:"_id"`
 	Title       string    `json:"title"`
@@ -25,15 +63,75 @@ type Todo struct {
 	UpdatedAt   time.Time `json:"-"`
 }
 
+// LoginAttemptStore keeps the audit trail of login attempts behind account
+// lockouts and the security events page.
+type LoginAttemptStore interface {
+	RecordLoginAttempt(ctx context.Context, attempt LoginAttempt) error
+	GetLoginAttempts(ctx context.Context, userID int, limit int) ([]*LoginAttempt, error)
+	// GetLoginFailures counts the wrong passwords and two-factor codes entered
+	// since the user last logged in successfully and returns when the latest
+	// one happened.
+	GetLoginFailures(ctx context.Context, userID int) (int, *time.Time, error)
+}
+
+// Reasons a login attempt failed, or for successful entries, why they were
+// recorded other than a plain login.
+const (
+	LoginUserNotFound    = "user_not_found"
+	LoginInvalidPassword = "invalid_password"
+	LoginAccountLocked   = "account_locked"
+	LoginPasswordReset   = "password_reset"
+	LoginSSO             = "sso"
+	LoginInvalidMFACode  = "invalid_mfa_code"
+)
+
+// LoginAttempt records a single attempt to log in. UserID is zero when the
+// identifier matched no user.
+type LoginAttempt struct {
+	ID         int       `json:"_id"`
+	UserID     int       `json:"-"`
+	Identifier string    `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Success    bool      `json:"success"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 type LoginUserPayload struct {
 	Text     string `json:"text" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
 
 type UserStore interface {
-	GetUserByEmail(email string) (*User, error)
-	GetUserByID(id int) (*User, error)
-	CreateUser(User) (int, error)
+	// GetUserByEmail finds a user by email, ignoring case.
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// GetUserByUsername finds a user by username, ignoring case.
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	// GetUserByLogin finds the user logging in with login, which is an email
+	// when it contains "@" and a username otherwise.
+	GetUserByLogin(ctx context.Context, login string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	CreateUser(ctx context.Context, user User) (int, error)
+	// MarkEmailVerified verifies the user's address if it is still email and
+	// unverified, and reports whether it did.
+	MarkEmailVerified(ctx context.Context, id int, email string, at time.Time) (bool, error)
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	// UpdateUser applies a profile update and returns the updated user. It
+	// fails with a conflict when the new username belongs to someone else.
+	UpdateUser(ctx context.Context, id int, payload UserUpdatePayload) (*User, error)
+	// DeleteUser schedules the user for deletion by marking them deleted at
+	// the given time. RestoreUser takes that back until PurgeUser removes the
+	// user and everything they own for good.
+	DeleteUser(ctx context.Context, id int, at time.Time) error
+	RestoreUser(ctx context.Context, id int) error
+	// GetUsersDeletedBefore returns the IDs of up to limit users marked
+	// deleted before the given time.
+	GetUsersDeletedBefore(ctx context.Context, before time.Time, limit int) ([]int, error)
+	// PurgeUser removes the user if they are still marked deleted before the
+	// given time, and reports whether it did, so an account restored since
+	// it was listed is kept.
+	PurgeUser(ctx context.Context, id int, deletedBefore time.Time) (bool, error)
 }
 
 type User struct {
@@ -45,12 +143,171 @@ type User struct {
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"-"`
+	// EmailVerifiedAt is nil until the user follows the verification link
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// DeletedAt is set while the account is scheduled for deletion
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// TOTPSecret is the encrypted TOTP secret, set from enrollment on
+	TOTPSecret string `json:"-"`
+	// TOTPEnabledAt is set once enrollment is confirmed and logins need a code
+	TOTPEnabledAt *time.Time `json:"mfa_enabled_at"`
+	// TOTPLastStep is the time step of the last code accepted, so each code
+	// works only once
+	TOTPLastStep int64 `json:"-"`
+}
+
+// MFAStore keeps the users' TOTP secrets, encrypted by the caller, and their
+// hashed recovery codes.
+type MFAStore interface {
+	// SetTOTPSecret stores a secret awaiting confirmation, replacing any
+	// earlier one, and leaves two-factor authentication off until EnableTOTP.
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	// EnableTOTP turns two-factor authentication on with the stored secret,
+	// records step as used and replaces the user's recovery codes.
+	EnableTOTP(ctx context.Context, userID int, step int64, at time.Time, recoveryCodeHashes []string) error
+	// DisableTOTP removes the secret and the recovery codes.
+	DisableTOTP(ctx context.Context, userID int) error
+	// UseTOTPStep records step as used unless it or a later one was used
+	// already, and reports whether it did.
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	// UseRecoveryCode spends the user's unused recovery code with the given
+	// hash and reports whether there was one.
+	UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) (bool, error)
+	// CountRecoveryCodes returns how many unused recovery codes the user has.
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+// MFACodePayload carries a code from an authenticator app, or a recovery code.
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required,max=64"`
+}
+
+// MFALoginPayload finishes a login that needs a second factor.
+type MFALoginPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=64"`
 }
 
 type RegisterUserPayload struct {
 	FirstName string `json:"first_name" validate:"required"`
 	LastName  string `json:"last_name" validate:"required"`
-	UserName  string `json:"username" validate:"required"`
+	UserName  string `json:"username" validate:"required,max=255,excludes=@"`
 	Email     string `json:"email" validate:"required,email"`
 	Password  string `json:"password" validate:"required"`
 }
+
+// UserUpdatePayload holds the fields of a partial profile update; nil fields are left untouched.
+type UserUpdatePayload struct {
+	FirstName *string `json:"first_name" validate:"omitempty,min=1,max=100"`
+	LastName  *string `json:"last_name" validate:"omitempty,min=1,max=100"`
+	UserName  *string `json:"username" validate:"omitempty,min=1,max=255,excludes=@"`
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID int, next RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+}
+
+// RefreshToken is the server-side record of a long-lived refresh token; only its hash is stored.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetStore keeps the hashed one-time tokens emailed to users who
+// forgot their password.
+type PasswordResetStore interface {
+	CreatePasswordResetToken(ctx context.Context, token PasswordResetToken) error
+	GetPasswordResetTokenByHash(ctx context.Context, hash string) (*PasswordResetToken, error)
+	// ConsumePasswordResetToken spends the unexpired, unused token with the
+	// given hash along with every other outstanding token of its user, and
+	// returns the user it belongs to.
+	ConsumePasswordResetToken(ctx context.Context, hash string, now time.Time) (int, error)
+}
+
+// PasswordResetToken is the server-side record of a password reset token; only its hash is stored.
+type PasswordResetToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type TokenRevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeUserTokens(ctx context.Context, userID int, before time.Time) error
+	GetUserTokenCutoff(ctx context.Context, userID int) (*time.Time, error)
+}
+
+type PersonalAccessTokenStore interface {
+	CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) (int, error)
+	// GetPersonalAccessTokens lists the user's tokens that are not revoked,
+	// newest first.
+	GetPersonalAccessTokens(ctx context.Context, userID int) ([]*PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*PersonalAccessToken, error)
+	// TouchPersonalAccessToken records that the token was used at at.
+	TouchPersonalAccessToken(ctx context.Context, id int, at time.Time) error
+	// RevokePersonalAccessToken revokes the user's token id, or returns a
+	// not found error if the user has no such active token.
+	RevokePersonalAccessToken(ctx context.Context, userID int, id int, at time.Time) error
+	// RevokeUserPersonalAccessTokens revokes every active token of the user.
+	RevokeUserPersonalAccessTokens(ctx context.Context, userID int, at time.Time) error
+}
+
+// PersonalAccessToken is a long-lived token a user creates for scripts, limited
+// to Scopes; only its hash is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type PersonalAccessTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=todos:read todos:write profile:read"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+// UserIdentityStore links users to the accounts they sign in with at external
+// OpenID Connect providers.
+type UserIdentityStore interface {
+	// GetUserIdentity finds the identity with the given subject at issuer.
+	GetUserIdentity(ctx context.Context, issuer, subject string) (*UserIdentity, error)
+	// CreateUserIdentity links an identity to its user. It fails with a
+	// conflict when the identity is linked already.
+	CreateUserIdentity(ctx context.Context, identity UserIdentity) (int, error)
+	// TouchUserIdentity records that the identity was used to log in at at.
+	TouchUserIdentity(ctx context.Context, id int, at time.Time) error
+}
+
+// UserIdentity is a user's account at an external identity provider, named
+// by the provider's issuer URL and its subject for the user.
+type UserIdentity struct {
+	ID      int
+	UserID  int
+	Issuer  string
+	Subject string
+	// Email is the address the provider reported when the identity was linked
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "net/http"
+
+// ResponseRecorder wraps a ResponseWriter to capture the status code and body
+// size written by a handler, for middleware that logs or measures responses.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status      int
+	Size        int
+	wroteHeader bool
+}
+
+// NewResponseRecorder wraps w. The status is 200 until the handler sets one.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.Size += n
+	return n, err
+}
@@ -2,9 +2,12 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
 
 	"github.com/Waris-Shaik/todo/types"
 	"github.com/go-playground/validator/v10"
@@ -12,9 +15,12 @@ import (
 
 func ParseJSON(r *http.Request, payload any) error {
 	if r.Body == nil {
-		return fmt.Errorf("missing request body")
+		return types.Validation("invalid_json", "missing request body")
 	}
-	return json.NewDecoder(r.Body).Decode(payload)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		return types.Validation("invalid_json", err.Error())
+	}
+	return nil
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -24,94 +30,133 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-func WriteError(w http.ResponseWriter, status int, err error) {
+// StatusCode maps an error to the HTTP status for its kind. Errors that are
+// not a *types.Error are treated as internal.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, types.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, types.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, types.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, types.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, types.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, types.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, types.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError writes err in the error envelope with its status and code.
+// Messages of untyped errors are not exposed to the client.
+func WriteError(w http.ResponseWriter, err error) {
+	var typed *types.Error
+	if !errors.As(err, &typed) {
+		typed = types.Internal(err)
+	}
+
 	response := struct {
-		Success bool   `json:"success"`
-		Error   string `json:"error"`
+		Success bool               `json:"success"`
+		Error   string             `json:"error"`
+		Code    string             `json:"code"`
+		Details []types.FieldError `json:"details,omitempty"`
 	}{
 		Success: false,
-		Error:   err.Error(),
+		Error:   typed.Message,
+		Code:    typed.Code,
+		Details: typed.Details,
 	}
 
-	WriteJSON(w, status, response)
+	WriteJSON(w, StatusCode(typed), response)
 }
 
-func ValidateRegisterUserPayload(payload *types.RegisterUserPayload) error {
-	err := validator.New().Struct(*payload)
-	if err != nil {
-		errorMessages := make([]string, 0)
-
-		for _, validationError := range err.(validator.ValidationErrors) {
-			errorMessages = append(errorMessages, validationError.Field())
-		}
-
-		if len(errorMessages) == 0 {
-			fmt.Println("this field is required:", errorMessages)
-		} else if len(errorMessages) > 1 {
-			fmt.Println("these fields are required:", errorMessages)
-		} else {
-			fmt.Println("validation error", errorMessages)
+// validate is shared by every request; validator.Validate caches struct
+// metadata and is safe for concurrent use.
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// report fields by their JSON names, which is what clients send
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
 		}
-		return fmt.Errorf("%v is required", errorMessages[0])
-	}
-	return nil
+		return name
+	})
+	return v
 }
 
-func ValidateLoginUserPayload(payload *types.LoginUserPayload) error {
-	err := validator.New().Struct(*payload)
-	if err != nil {
-		errorMessages := make([]string, 0)
+// Validate checks payload against its `validate` struct tags and returns a
+// validation error listing every failing field.
+func Validate(payload any) error {
+	err := validate.Struct(payload)
+	if err == nil {
+		return nil
+	}
 
-		for _, validationError := range err.(validator.ValidationErrors) {
-			errorMessages = append(errorMessages, validationError.Field())
-		}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return types.Internal(err)
+	}
 
-		if len(errorMessages) == 0 {
-			fmt.Println("this field is required:", errorMessages)
-		} else if len(errorMessages) > 1 {
-			fmt.Println("these fields are required:", errorMessages)
-		} else {
-			fmt.Println("validation error", errorMessages)
-		}
-		return fmt.Errorf("%v is required", errorMessages[0])
+	details := make([]types.FieldError, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		details = append(details, types.FieldError{
+			Field:   fieldError.Field(),
+			Rule:    fieldError.Tag(),
+			Message: fieldErrorMessage(fieldError),
+		})
 	}
-	return nil
+	return types.InvalidFields(details)
 }
 
-func ValidateTodoPayload(payload *types.TodoPayload) error {
-	err := validator.New().Struct(*payload)
-	if err != nil {
-		errorMessages := make([]string, 0)
-
-		for _, validationError := range err.(validator.ValidationErrors) {
-			errorMessages = append(errorMessages, validationError.Field())
-		}
-
-		if len(errorMessages) == 0 {
-			fmt.Println("this field is required:", errorMessages)
-		} else if len(errorMessages) > 1 {
-			fmt.Println("these fields are required:", errorMessages)
-		} else {
-			fmt.Println("validation error", errorMessages)
-		}
-		return fmt.Errorf("%v is required", errorMessages[0])
+func fieldErrorMessage(fieldError validator.FieldError) string {
+	field := fieldError.Field()
+	switch fieldError.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", field)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(fieldError.Param(), " ", ", "))
+	case "min":
+		return fmt.Sprintf("%s must be at least %s%s", field, fieldError.Param(), sizeUnit(fieldError.Kind(), fieldError.Param()))
+	case "max":
+		return fmt.Sprintf("%s must be at most %s%s", field, fieldError.Param(), sizeUnit(fieldError.Kind(), fieldError.Param()))
+	case "excludes":
+		return fmt.Sprintf("%s must not contain %q", field, fieldError.Param())
+	default:
+		return fmt.Sprintf("%s is invalid", field)
 	}
-	return nil
 }
 
-func MatchPasswordCriteria(password *string) error {
-	const (
-		minPasswordLen = 6
-		maxPasswordLen = 12
-	)
-
-	if len(*password) < minPasswordLen {
-		return fmt.Errorf("password atleast should contain %d charcters", minPasswordLen)
+// sizeUnit names what min and max count for a field of kind.
+func sizeUnit(kind reflect.Kind, param string) string {
+	unit := ""
+	switch kind {
+	case reflect.String:
+		unit = " character"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " item"
 	}
-	if len(*password) > maxPasswordLen {
-		return fmt.Errorf("password must not be greater than %d characters", maxPasswordLen)
+	if unit != "" && param != "1" {
+		unit += "s"
 	}
-	return nil
+	return unit
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func GetNodeENV(key string) string {